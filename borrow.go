@@ -0,0 +1,90 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Borrow is like TryGet, but also guards the mapping until the returned
+// release function is called, typically when a callback that uses the value
+// returns.  While the mapping is borrowed, DeleteAndWait and ClearAndWait
+// block instead of returning, so the value's owner knows that no callback is
+// still using it.
+//
+// Once a mapping is being deleted, Borrow returns the same error as TryGet.
+// Calling release more than once has no effect.
+func (mapper *Mapper) Borrow(key Key) (goValue interface{}, release func(), err error) {
+	// Even WithReadMostly, we need the lock to order Borrow against
+	// DeleteAndWait.
+	mapper.mux.RLock()
+	e, err := mapper.findLocked(key)
+	if err != nil {
+		mapper.mux.RUnlock()
+		return nil, nil, mapper.miss(key, err)
+	}
+	wg := e.borrowers()
+	wg.Add(1)
+	mapper.mux.RUnlock()
+
+	var released int32
+	release = func() {
+		if atomic.CompareAndSwapInt32(&released, 0, 1) {
+			wg.Done()
+		}
+	}
+	return e.value, release, nil
+}
+
+// DeleteAndWait is like TryDelete, but then waits for all outstanding borrows
+// of the mapping to be released.  New borrows are rejected as soon as it is
+// called.  The deletion hooks are called after the wait, so they can safely
+// free resources used by the borrowers.
+//
+// DeleteAndWait must not be called while holding a borrow of the same key,
+// as it would wait forever.
+func (mapper *Mapper) DeleteAndWait(key Key) (deleted bool) {
+	mapper.lock()
+	e := mapper.removeLocked(key)
+	mapper.mux.Unlock()
+	if e == nil {
+		return false
+	}
+	e.waitBorrows()
+	mapper.deleted(e)
+	return true
+}
+
+// ClearAndWait is like Clear, but waits for the outstanding borrows of each
+// mapping to be released before calling its deletion hooks.
+func (mapper *Mapper) ClearAndWait() {
+	mapper.lock()
+	entries := mapper.clearLocked()
+	mapper.mux.Unlock()
+	for _, e := range entries {
+		e.waitBorrows()
+		mapper.deleted(e)
+	}
+}
+
+// borrowers returns the WaitGroup of e's borrows, allocating it on the first
+// Borrow.  As borrows are added with mux read-locked, concurrent first
+// Borrows race to allocate it.
+func (e *entry) borrowers() *sync.WaitGroup {
+	if wg := e.borrows.Load(); wg != nil {
+		return wg
+	}
+	e.borrows.CompareAndSwap(nil, new(sync.WaitGroup))
+	return e.borrows.Load()
+}
+
+// waitBorrows waits for the borrows of e, which has been removed, to be
+// released.
+func (e *entry) waitBorrows() {
+	if wg := e.borrows.Load(); wg != nil {
+		wg.Wait()
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"testing"
+	"time"
+
+	"go.jpap.org/mapper"
+)
+
+func TestBorrowDeleteAndWait(t *testing.T) {
+	var m mapper.Mapper
+	key := m.MapValue("value")
+
+	v, release, err := m.Borrow(key)
+	if err != nil || v != "value" {
+		t.Fatalf("Borrow returned %v, %v", v, err)
+	}
+
+	done := make(chan bool)
+	go func() {
+		done <- m.DeleteAndWait(key)
+	}()
+
+	// New borrows are rejected once deletion begins.
+	for {
+		_, r, err := m.Borrow(key)
+		if err != nil {
+			break
+		}
+		r()
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-done:
+		t.Fatal("DeleteAndWait returned while borrowed")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	release()
+	release() // no effect
+	if deleted := <-done; !deleted {
+		t.Fatal("DeleteAndWait returned false")
+	}
+	if m.DeleteAndWait(key) {
+		t.Fatal("second DeleteAndWait returned true")
+	}
+}
+
+func TestBorrowClearAndWait(t *testing.T) {
+	m := mapper.New(mapper.WithHandleTable())
+	key := m.MapValue("value")
+
+	_, release, err := m.Borrow(key)
+	if err != nil {
+		t.Fatalf("Borrow: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		m.ClearAndWait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("ClearAndWait returned while borrowed")
+	case <-time.After(10 * time.Millisecond):
+	}
+	release()
+	<-done
+}
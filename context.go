@@ -0,0 +1,55 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+import "context"
+
+// maxCanceled is the number of tombstones kept for canceled mappings.
+const maxCanceled = 1024
+
+// MapValueContext is like MapValue, but the mapping is deleted when ctx is
+// done, that is, when it is canceled or its deadline passes.  A later lookup
+// of the key returns a *UseAfterDeleteError whose Cause is the cause of ctx,
+// so that errors.Is(err, context.Canceled) reports a canceled key:
+//
+//   key := mapper.G.MapValueContext(ctx, v)
+//   ...
+//   if _, err := mapper.G.TryGet(key); errors.Is(err, context.Canceled) {
+//     // the request is gone
+//   }
+//
+// The mapping may also be deleted before ctx is done, by any other means.  No
+// goroutine is kept per mapping, see context.AfterFunc.
+func (mapper *Mapper) MapValueContext(ctx context.Context, goValue interface{}, opts ...MapOption) Key {
+	e := mapper.newEntry(goValue, opts)
+	e.extra() // for stop
+	key := mapper.mapValue(e)
+	stop := context.AfterFunc(ctx, func() {
+		mapper.removeEntry(e, context.Cause(ctx))
+	})
+
+	mapper.mux.Lock()
+	mapped := mapper.mappedLocked(e)
+	if mapped {
+		e.x.stop = stop
+	}
+	mapper.mux.Unlock()
+	if !mapped {
+		// Deleted already, so deleted(e) could not stop the callback.
+		stop()
+	}
+	return key
+}
+
+// buryCanceledLocked records a tombstone for a key deleted by the cause of
+// its context.
+func (mapper *Mapper) buryCanceledLocked(key Key, cause error) {
+	q := mapper.canceled.Load()
+	if q == nil {
+		q = &quarantine{max: maxCanceled}
+		mapper.canceled.Store(q)
+	}
+	q.bury(&tombstone{key: key, deleted: mapper.now(), cause: cause})
+}
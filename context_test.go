@@ -0,0 +1,116 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.jpap.org/mapper"
+)
+
+// waitDeleted waits for key to be deleted by its context's callback, which
+// runs in its own goroutine.
+func waitDeleted(t *testing.T, m *mapper.Mapper, key mapper.Key) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Has(key) {
+		if time.Now().After(deadline) {
+			t.Fatalf("key 0x%x not deleted", key.Handle())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMapValueContextCancel(t *testing.T) {
+	deleted := make(chan interface{}, 1)
+	m := mapper.New(mapper.WithOnDelete(func(k mapper.Key, v interface{}) {
+		deleted <- v
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	key := m.MapValueContext(ctx, "value")
+	if got := m.Get(key); got != "value" {
+		t.Fatalf("Get returned %v", got)
+	}
+	cancel()
+	if v := <-deleted; v != "value" {
+		t.Fatalf("OnDelete called for %v", v)
+	}
+
+	_, err := m.TryGet(key)
+	var uade *mapper.UseAfterDeleteError
+	if !errors.As(err, &uade) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("TryGet of canceled key returned %v", err)
+	}
+	if uade.Deleted.IsZero() {
+		t.Fatal("UseAfterDeleteError has no deletion time")
+	}
+}
+
+func TestMapValueContextDeadline(t *testing.T) {
+	m := mapper.New(mapper.WithHandleTable())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	key := m.MapValueContext(ctx, "value")
+	waitDeleted(t, m, key)
+	if _, err := m.TryGet(key); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("TryGet of expired key returned %v", err)
+	}
+}
+
+func TestMapValueContextReadMostly(t *testing.T) {
+	m := mapper.New(mapper.WithReadMostly())
+	ctx, cancel := context.WithCancel(context.Background())
+	key := m.MapValueContext(ctx, "value")
+	cancel()
+	waitDeleted(t, m, key)
+	if _, err := m.TryGet(key); !errors.Is(err, context.Canceled) {
+		t.Fatalf("TryGet of canceled key returned %v", err)
+	}
+}
+
+func TestMapValueContextDeleted(t *testing.T) {
+	var deleted atomic.Int32
+	m := mapper.New(mapper.WithOnDelete(func(k mapper.Key, v interface{}) {
+		deleted.Add(1)
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.Delete(m.MapValueContext(ctx, "delete"))
+	m.MapValueContext(ctx, "clear")
+	m.Clear()
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	if n := deleted.Load(); n != 2 {
+		t.Fatalf("OnDelete called %d times, want 2", n)
+	}
+
+	// A context that is already done deletes the mapping immediately.
+	key := m.MapValueContext(ctx, "done")
+	waitDeleted(t, m, key)
+}
+
+func TestMapValueContextGoroutines(t *testing.T) {
+	var m mapper.Mapper
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	before := runtime.NumGoroutine()
+	keys := make([]mapper.Key, 1000)
+	for i := range keys {
+		keys[i] = m.MapValueContext(ctx, i)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("%d goroutines after mapping, %d before", n, before)
+	}
+	for _, key := range keys {
+		m.Delete(key)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Mapping describes a live mapping.
+type Mapping struct {
+	Key   Key
+	Value interface{}
+
+	// Created and Stack record when and where the mapping was created.  They
+	// are only set in debug mode; see WithDebug.
+	Created time.Time
+	Stack   []uintptr
+}
+
+// String formats the mapping, together with its creation stack, if any.
+func (mp Mapping) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "0x%x (%v) -> %T", mp.Key.v, mp.Key.Kind(), mp.Value)
+	if !mp.Created.IsZero() {
+		fmt.Fprintf(&b, ", created %v", mp.Created.Format(time.RFC3339Nano))
+	}
+	writeStack(&b, mp.Stack)
+	return b.String()
+}
+
+// Leaks returns all live mappings, like Mappings.  It is typically called
+// when a program or test has finished, when all mappings should have been
+// deleted.
+func (mapper *Mapper) Leaks() []Mapping {
+	return mapper.Mappings()
+}
+
+// Mappings returns all live mappings, oldest first in debug mode.
+func (mapper *Mapper) Mappings() []Mapping {
+	mapper.mux.RLock()
+	entries := mapper.entriesLocked()
+	mapper.mux.RUnlock()
+
+	leaks := make([]Mapping, len(entries))
+	for i, e := range entries {
+		leaks[i] = e.mapping()
+	}
+	sort.SliceStable(leaks, func(i, j int) bool {
+		return leaks[i].Created.Before(leaks[j].Created)
+	})
+	return leaks
+}
+
+func (e *entry) mapping() Mapping {
+	m := Mapping{Key: e.key, Value: e.value}
+	if e.x != nil {
+		m.Created, m.Stack = e.x.created, e.x.stack
+	}
+	return m
+}
+
+// debugging reports whether the mapper is in debug mode.
+func (mapper *Mapper) debugging() bool {
+	return debugDefault || mapper.debug
+}
+
+// maxStackDepth limits the number of frames recorded in debug mode.
+const maxStackDepth = 32
+
+// callers returns the stack of the caller of the exported Mapper method that
+// called it; frames inside this package are trimmed by writeStack.
+func callers() []uintptr {
+	pc := make([]uintptr, maxStackDepth)
+	// Skip runtime.Callers and callers itself.
+	n := runtime.Callers(2, pc)
+	return pc[:n]
+}
+
+// Frames returns the frames of the mapping's creation stack, if any, with
+// the frames inside this package omitted.
+func (mp Mapping) Frames() []runtime.Frame {
+	return stackFrames(mp.Stack)
+}
+
+// stackFrames returns the frames of stack, skipping those that are inside
+// this package.
+func stackFrames(stack []uintptr) []runtime.Frame {
+	if len(stack) == 0 {
+		return nil
+	}
+	var out []runtime.Frame
+	frames := runtime.CallersFrames(stack)
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, pkgPath+".") {
+			out = append(out, frame)
+		}
+		if !more {
+			break
+		}
+	}
+	return out
+}
+
+// writeStack writes stack to b, one frame per line, skipping the frames that
+// are inside this package.
+func writeStack(b *strings.Builder, stack []uintptr) {
+	for _, frame := range stackFrames(stack) {
+		fmt.Fprintf(b, "\n\t%s\n\t\t%s:%d", frame.Function, frame.File, frame.Line)
+	}
+}
+
+// pkgPath is the import path of this package.
+const pkgPath = "go.jpap.org/mapper"
@@ -0,0 +1,10 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build mapperdebug
+
+package mapper
+
+// debugDefault enables debug mode and profiling for all mappers.
+const debugDefault = true
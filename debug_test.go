@@ -0,0 +1,45 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"strings"
+	"testing"
+
+	"go.jpap.org/mapper"
+	"go.jpap.org/mapper/mappertest"
+)
+
+func TestLeaks(t *testing.T) {
+	m := mapper.New(mapper.WithDebug())
+
+	first := m.MapValue("first")
+	leak := m.MapValue("leak")
+	m.Delete(first)
+
+	leaks := m.Leaks()
+	if len(leaks) != 1 || leaks[0].Key != leak {
+		t.Fatalf("Leaks returned %v", leaks)
+	}
+	if leaks[0].Created.IsZero() {
+		t.Error("leak has no creation time")
+	}
+	s := leaks[0].String()
+	if !strings.Contains(s, "TestLeaks") || !strings.Contains(s, "debug_test.go") {
+		t.Errorf("leak does not name its creation site:\n%s", s)
+	}
+	if strings.Contains(s, "(*Mapper).MapValue") {
+		t.Errorf("leak stack includes mapper frames:\n%s", s)
+	}
+
+	err := mappertest.Check(m)
+	if err == nil || !strings.Contains(err.Error(), "1 leaked mappings") {
+		t.Fatalf("Check returned %v", err)
+	}
+	m.Delete(leak)
+	if err := mappertest.Check(m); err != nil {
+		t.Fatalf("Check returned %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package debughttp serves the live mappings of a running program's mappers
+// via HTTP, as HTML or JSON, in the manner of net/http/pprof.
+//
+// The package is typically only imported for the side effect of registering
+// its HTTP handler under /debug/mapper/:
+//
+//   import _ "go.jpap.org/mapper/debughttp"
+//
+// The index page lists each registered mapper, with its size and a breakdown
+// of its key kinds and value types.  Follow a mapper's link (or add
+// ?name=<mapper>) to list its mappings, with their creation time and stack
+// when the mapper is in debug mode.  Add ?format=json to either page to fetch
+// it as JSON.
+package debughttp // go.jpap.org/mapper/debughttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"go.jpap.org/mapper"
+)
+
+func init() {
+	http.Handle("/debug/mapper/", Handler())
+}
+
+// Handler returns an HTTP handler that serves the mappers registered with
+// mapper.NewNamed, including mapper.G.
+func Handler() http.Handler {
+	return http.HandlerFunc(serve)
+}
+
+// Summary describes a mapper on the index page.
+type Summary struct {
+	Name  string         `json:"name"`
+	Stats mapper.Stats   `json:"stats"`
+	Kinds map[string]int `json:"kinds"` // number of mappings by key kind
+	Types map[string]int `json:"types"` // number of mappings by value type
+}
+
+// Detail describes a mapper and its mappings.
+type Detail struct {
+	Summary
+	Mappings []Mapping `json:"mappings"`
+}
+
+// Mapping describes a single mapping.
+type Mapping struct {
+	Key     string     `json:"key"`
+	Kind    string     `json:"kind"`
+	Type    string     `json:"type"`
+	Created *time.Time `json:"created,omitempty"`
+	Age     string     `json:"age,omitempty"`
+	Stack   []string   `json:"stack,omitempty"`
+}
+
+func serve(w http.ResponseWriter, r *http.Request) {
+	asJSON := r.FormValue("format") == "json"
+
+	name := r.FormValue("name")
+	if name == "" {
+		mappers := mapper.Mappers()
+		summaries := make([]Summary, len(mappers))
+		for i, m := range mappers {
+			summaries[i] = summarize(m, m.Mappings())
+		}
+		if asJSON {
+			writeJSON(w, summaries)
+			return
+		}
+		execute(w, indexTemplate, summaries)
+		return
+	}
+
+	m, ok := mapper.Named(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown mapper %q", name), http.StatusNotFound)
+		return
+	}
+	mappings := m.Mappings()
+	detail := Detail{Summary: summarize(m, mappings)}
+	now := time.Now()
+	for _, mp := range mappings {
+		detail.Mappings = append(detail.Mappings, describe(mp, now))
+	}
+	if asJSON {
+		writeJSON(w, detail)
+		return
+	}
+	execute(w, detailTemplate, detail)
+}
+
+func summarize(m *mapper.Mapper, mappings []mapper.Mapping) Summary {
+	s := Summary{
+		Name:  m.Name(),
+		Stats: m.Stats(),
+		Kinds: make(map[string]int),
+		Types: make(map[string]int),
+	}
+	for _, mp := range mappings {
+		s.Kinds[mp.Key.Kind().String()]++
+		s.Types[fmt.Sprintf("%T", mp.Value)]++
+	}
+	return s
+}
+
+func describe(mp mapper.Mapping, now time.Time) Mapping {
+	d := Mapping{
+		Key:  fmt.Sprintf("0x%x", mp.Key.Handle()),
+		Kind: mp.Key.Kind().String(),
+		Type: fmt.Sprintf("%T", mp.Value),
+	}
+	if !mp.Created.IsZero() {
+		created := mp.Created
+		d.Created = &created
+		d.Age = now.Sub(created).Round(time.Millisecond).String()
+	}
+	for _, frame := range mp.Frames() {
+		d.Stack = append(d.Stack, fmt.Sprintf("%s\n\t%s:%d", frame.Function, frame.File, frame.Line))
+	}
+	return d
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func execute(w http.ResponseWriter, t *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><title>/debug/mapper/</title></head>
+<body>
+<h1>/debug/mapper/</h1>
+<p><a href="?format=json">JSON</a></p>
+<table>
+<tr><th>Mapper</th><th>Live</th><th>High water</th><th>Key kinds</th><th>Value types</th></tr>
+{{range .}}<tr>
+<td><a href="?name={{.Name}}">{{.Name}}</a></td>
+<td>{{.Stats.Live}}</td>
+<td>{{.Stats.HighWater}}</td>
+<td>{{range $k, $n := .Kinds}}{{$k}}: {{$n}}<br>{{end}}</td>
+<td>{{range $t, $n := .Types}}{{$t}}: {{$n}}<br>{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+var detailTemplate = template.Must(template.New("detail").Parse(`<!DOCTYPE html>
+<html>
+<head><title>/debug/mapper/ {{.Name}}</title></head>
+<body>
+<h1><a href="?">/debug/mapper/</a> {{.Name}}</h1>
+<p><a href="?name={{.Name}}&amp;format=json">JSON</a></p>
+<p>
+{{.Stats.Live}} live, {{.Stats.HighWater}} high water,
+{{.Stats.Maps}} maps, {{.Stats.Deletes}} deletes,
+{{.Stats.Hits}} hits, {{.Stats.Misses}} misses.
+</p>
+<table>
+<tr><th>Key</th><th>Kind</th><th>Type</th><th>Age</th><th>Created by</th></tr>
+{{range .Mappings}}<tr>
+<td>{{.Key}}</td>
+<td>{{.Kind}}</td>
+<td>{{.Type}}</td>
+<td>{{.Age}}</td>
+<td><pre>{{range .Stack}}{{.}}
+{{end}}</pre></td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
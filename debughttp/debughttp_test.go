@@ -0,0 +1,87 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debughttp_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.jpap.org/mapper"
+	"go.jpap.org/mapper/debughttp"
+)
+
+type callback struct{}
+
+func TestHandler(t *testing.T) {
+	m := mapper.NewNamed("test", mapper.WithDebug())
+	defer m.Unregister()
+
+	key := m.MapValue(&callback{})
+	defer m.Delete(key)
+
+	srv := httptest.NewServer(debughttp.Handler())
+	defer srv.Close()
+
+	var summaries []debughttp.Summary
+	getJSON(t, srv.URL+"/?format=json", &summaries)
+	found := false
+	for _, s := range summaries {
+		if s.Name == "test" {
+			found = true
+			if s.Stats.Live != 1 || s.Kinds["counting key"] != 1 || s.Types["*debughttp_test.callback"] != 1 {
+				t.Errorf("summary is %+v", s)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("mapper not listed in %+v", summaries)
+	}
+
+	var detail debughttp.Detail
+	getJSON(t, srv.URL+"/?name=test&format=json", &detail)
+	if len(detail.Mappings) != 1 {
+		t.Fatalf("detail is %+v", detail)
+	}
+	mp := detail.Mappings[0]
+	if mp.Created == nil || len(mp.Stack) == 0 || !strings.Contains(mp.Stack[0], "TestHandler") {
+		t.Errorf("mapping is %+v", mp)
+	}
+
+	for _, url := range []string{srv.URL + "/", srv.URL + "/?name=test"} {
+		body := get(t, url, http.StatusOK)
+		if !strings.Contains(body, "test") || !strings.Contains(body, "callback") {
+			t.Errorf("%s: HTML does not list the mapping:\n%s", url, body)
+		}
+	}
+	get(t, srv.URL+"/?name=missing", http.StatusNotFound)
+}
+
+func get(t *testing.T, url string, status int) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		t.Fatalf("%s: status %d, want %d", url, resp.StatusCode, status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func getJSON(t *testing.T, url string, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(get(t, url, http.StatusOK)), v); err != nil {
+		t.Fatalf("%s: %v", url, err)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// NotMappedError is returned when a Key has no mapping.
+type NotMappedError struct {
+	Key    Key
+	Kind   KeyKind
+	Mapper string // name of the mapper, if created by NewNamed
+
+	// Recent holds the mapper's recent operations, up to and including the
+	// failed lookup, when it has a flight recorder; see WithFlightRecorder.
+	Recent []Event
+}
+
+func (e *NotMappedError) Error() string {
+	var b strings.Builder
+	writeMapperName(&b, e.Mapper)
+	fmt.Fprintf(&b, "key not mapped: 0x%x (%v)", e.Key.v, e.Kind)
+	writeRecent(&b, e.Recent)
+	return b.String()
+}
+
+// UseAfterDeleteError is returned when a Key was once mapped, but its mapping
+// has since been deleted.  Like any key that is not mapped, it also matches
+// *NotMappedError with errors.As.
+type UseAfterDeleteError struct {
+	Key    Key
+	Mapper string // name of the mapper, if created by NewNamed
+
+	// Cleared is set when the key was issued before the mapper was last
+	// cleared, so its mapping was deleted no later than by Clear.
+	Cleared bool
+
+	// Cause is set when the mapping was deleted because the context of
+	// MapValueContext was done, and holds the context's cause.
+	Cause error
+
+	// Deleted and Stack record when and where the mapping was deleted.  They
+	// are only known for a key that is still in quarantine, see
+	// WithQuarantine, or that was canceled, and Stack only in debug mode.
+	Deleted time.Time
+	Stack   []uintptr
+
+	// Recent holds the mapper's recent operations, as for NotMappedError.
+	Recent []Event
+}
+
+func (e *UseAfterDeleteError) Error() string {
+	var b strings.Builder
+	op, done := "delete", "deleted"
+	switch {
+	case e.Cause != nil:
+		op, done = "cancel", "canceled"
+	case e.Cleared:
+		op, done = "clear", "cleared"
+	}
+	writeMapperName(&b, e.Mapper)
+	fmt.Fprintf(&b, "key used after %s: 0x%x", op, e.Key.v)
+	if !e.Deleted.IsZero() {
+		fmt.Fprintf(&b, " (%s at %v)", done, e.Deleted.Format(time.RFC3339Nano))
+	}
+	if e.Cause != nil {
+		fmt.Fprintf(&b, ": %v", e.Cause)
+	}
+	writeStack(&b, e.Stack)
+	writeRecent(&b, e.Recent)
+	return b.String()
+}
+
+func (e *UseAfterDeleteError) Unwrap() error {
+	return e.Cause
+}
+
+// As lets errors.As match a *UseAfterDeleteError as a *NotMappedError, as the
+// key is not mapped either way.
+func (e *UseAfterDeleteError) As(target interface{}) bool {
+	return asNotMapped(target, e.Key, e.Mapper, e.Recent)
+}
+
+// WrongMapperError is returned when a counting Key was issued by another
+// mapper, as told by the tag that a Mapper created WithTag encodes in its keys.
+// As the key is not mapped by this mapper, it also matches *NotMappedError
+// with errors.As.
+type WrongMapperError struct {
+	Key    Key
+	Mapper string // name of the mapper, if created by NewNamed
+	Owner  string // name of the mapper that issued the key, if registered
+
+	// Recent holds the mapper's recent operations, as for NotMappedError.
+	Recent []Event
+}
+
+func (e *WrongMapperError) Error() string {
+	var b strings.Builder
+	writeMapperName(&b, e.Mapper)
+	fmt.Fprintf(&b, "key from wrong mapper: 0x%x", e.Key.v)
+	if e.Owner != "" {
+		fmt.Fprintf(&b, " (issued by mapper %s)", e.Owner)
+	}
+	writeRecent(&b, e.Recent)
+	return b.String()
+}
+
+// As lets errors.As match a *WrongMapperError as a *NotMappedError.
+func (e *WrongMapperError) As(target interface{}) bool {
+	return asNotMapped(target, e.Key, e.Mapper, e.Recent)
+}
+
+// InvalidHandleError is returned when the check bits of a counting Key are
+// wrong, so it was not issued by a Mapper created WithHardenedHandles, but
+// forged or corrupted, e.g. by C code that wrote garbage over a handle.  As
+// such a key is not mapped, it also matches *NotMappedError with errors.As.
+type InvalidHandleError struct {
+	Key    Key
+	Mapper string // name of the mapper, if created by NewNamed
+
+	// Recent holds the mapper's recent operations, as for NotMappedError.
+	Recent []Event
+}
+
+func (e *InvalidHandleError) Error() string {
+	var b strings.Builder
+	writeMapperName(&b, e.Mapper)
+	fmt.Fprintf(&b, "invalid handle: 0x%x", e.Key.v)
+	writeRecent(&b, e.Recent)
+	return b.String()
+}
+
+// As lets errors.As match an *InvalidHandleError as a *NotMappedError.
+func (e *InvalidHandleError) As(target interface{}) bool {
+	return asNotMapped(target, e.Key, e.Mapper, e.Recent)
+}
+
+// OverReleaseError is returned by Release when the mapping has already been
+// deleted, typically because it was released more times than it was retained.
+type OverReleaseError struct {
+	Key Key
+	Err error // why the key is not mapped
+}
+
+func (e *OverReleaseError) Error() string {
+	return fmt.Sprintf("key over-released: 0x%x: %v", e.Key.v, e.Err)
+}
+
+func (e *OverReleaseError) Unwrap() error {
+	return e.Err
+}
+
+// asNotMapped sets target to a *NotMappedError for key, if it is a
+// **NotMappedError, for the As methods of the errors for keys that are not
+// mapped.
+func asNotMapped(target interface{}, key Key, mapper string, recent []Event) bool {
+	nme, ok := target.(**NotMappedError)
+	if !ok {
+		return false
+	}
+	*nme = &NotMappedError{Key: key, Kind: key.Kind(), Mapper: mapper, Recent: recent}
+	return true
+}
+
+func writeMapperName(b *strings.Builder, name string) {
+	if name != "" {
+		fmt.Fprintf(b, "mapper %s: ", name)
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+import (
+	"container/heap"
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EvictReason says why a mapping was evicted.
+type EvictReason int
+
+const (
+	// EvictExpired is the reason for a mapping whose TTL has passed.
+	EvictExpired EvictReason = iota
+	// EvictIdle is the reason for a mapping that was not looked up within the
+	// mapper's idle timeout.
+	EvictIdle
+	// EvictCapacity is the reason for the least recently used mapping of a
+	// mapper that is full.
+	EvictCapacity
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictExpired:
+		return "expired"
+	case EvictIdle:
+		return "idle"
+	case EvictCapacity:
+		return "capacity"
+	}
+	return fmt.Sprintf("EvictReason(%d)", int(r))
+}
+
+// janitorInterval is how often the janitor expires mappings.
+const janitorInterval = 100 * time.Millisecond
+
+// now returns the current time from the mapper's clock.
+func (mapper *Mapper) now() time.Time {
+	if mapper.clock != nil {
+		return mapper.clock()
+	}
+	return time.Now()
+}
+
+// Expire evicts the mappings whose TTL or idle timeout has passed, according
+// to the mapper's clock, and returns how many it evicted.  It is called
+// periodically by a janitor goroutine shared by all mappers, so it only needs
+// to be called directly to expire mappings without delay, typically in tests
+// that advance a clock set WithClock.
+func (mapper *Mapper) Expire() int {
+	now := mapper.now()
+	var evicted []*entry
+	var reasons []EvictReason
+
+	mapper.lock()
+	for len(mapper.deadlines) > 0 && !mapper.deadlines[0].x.deadline.After(now) {
+		e := mapper.deadlines[0]
+		mapper.removeLocked(e.key)
+		evicted = append(evicted, e)
+		reasons = append(reasons, EvictExpired)
+	}
+	if mapper.idleTimeout > 0 {
+		for _, e := range mapper.lru.idle(now.Add(-mapper.idleTimeout)) {
+			mapper.removeLocked(e.key)
+			evicted = append(evicted, e)
+			reasons = append(reasons, EvictIdle)
+		}
+	}
+	mapper.mux.Unlock()
+
+	for i, e := range evicted {
+		mapper.evicted(e, reasons[i])
+	}
+	return len(evicted)
+}
+
+// trackLocked starts tracking a new mapping for expiry and eviction.  It
+// returns the mappings that it removed to make room for it, whose hooks must
+// be called with evicted once mux is released.
+func (mapper *Mapper) trackLocked(e *entry) (full []*entry) {
+	now := mapper.now()
+	if e.x != nil && e.x.ttl > 0 {
+		e.x.deadline = now.Add(e.x.ttl)
+		heap.Push(&mapper.deadlines, e)
+	}
+	if mapper.lru != nil {
+		mapper.lru.push(e, now)
+		if mapper.capacity > 0 {
+			for _, old := range mapper.lru.over(mapper.capacity) {
+				mapper.removeLocked(old.key)
+				full = append(full, old)
+			}
+		}
+	}
+	mapper.updateJanitorLocked()
+	return full
+}
+
+// untrackLocked stops tracking a mapping that was removed.
+func (mapper *Mapper) untrackLocked(e *entry) {
+	if e.x != nil && !e.x.deadline.IsZero() {
+		heap.Remove(&mapper.deadlines, e.x.deadlineIndex)
+		e.x.deadline = time.Time{}
+	}
+	if mapper.lru != nil {
+		mapper.lru.remove(e)
+	}
+	mapper.updateJanitorLocked()
+}
+
+// touch marks a mapping as used, for its idle timeout and LRU order.
+func (mapper *Mapper) touch(e *entry) {
+	if mapper.lru != nil {
+		mapper.lru.touch(e, mapper.now())
+	}
+}
+
+// evicted calls the eviction hooks of an entry that has been evicted,
+// followed by its deletion hooks.  Like deleted, it must be called without mux
+// held.
+func (mapper *Mapper) evicted(e *entry, reason EvictReason) {
+	if e.x != nil && e.x.onEvict != nil {
+		e.x.onEvict(e.key, e.value, reason)
+	}
+	if mapper.onEvict != nil {
+		mapper.onEvict(e.key, e.value, reason)
+	}
+	mapper.deleted(e)
+}
+
+// evictedAll calls evicted for mappings removed for capacity.
+func (mapper *Mapper) evictedAll(full []*entry) {
+	for _, e := range full {
+		mapper.evicted(e, EvictCapacity)
+	}
+}
+
+// updateJanitorLocked registers the mapper with the janitor while it has
+// mappings that may expire, and unregisters it otherwise, so that the janitor
+// does not keep an idle mapper alive.
+func (mapper *Mapper) updateJanitorLocked() {
+	expiring := len(mapper.deadlines) > 0 || (mapper.idleTimeout > 0 && mapper.lru.len() > 0)
+	if expiring == mapper.expiring {
+		return
+	}
+	mapper.expiring = expiring
+	if expiring {
+		theJanitor.add(mapper)
+	} else {
+		theJanitor.remove(mapper)
+	}
+}
+
+// deadlineHeap orders the mappings created with a TTL by deadline.  Their
+// entries all have extra state.  It is protected by Mapper.mux.
+type deadlineHeap []*entry
+
+func (h deadlineHeap) Len() int           { return len(h) }
+func (h deadlineHeap) Less(i, j int) bool { return h[i].x.deadline.Before(h[j].x.deadline) }
+
+func (h deadlineHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].x.deadlineIndex = i
+	h[j].x.deadlineIndex = j
+}
+
+func (h *deadlineHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.x.deadlineIndex = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *deadlineHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+// lru lists the mappings of a mapper with an idle timeout or capacity, most
+// recently used first.  Its entries are added and removed with Mapper.mux
+// held, but lookups reorder them with only the lru's own lock, so that they
+// can hold Mapper.mux read-locked, or not at all.  Its entries all have extra
+// state, see Mapper.newEntry.
+type lru struct {
+	mux  sync.Mutex
+	list list.List // of *entry
+}
+
+func (l *lru) push(e *entry, now time.Time) {
+	l.mux.Lock()
+	e.x.used = now
+	e.x.elem = l.list.PushFront(e)
+	l.mux.Unlock()
+}
+
+func (l *lru) remove(e *entry) {
+	l.mux.Lock()
+	if e.x.elem != nil {
+		l.list.Remove(e.x.elem)
+		e.x.elem = nil
+	}
+	l.mux.Unlock()
+}
+
+func (l *lru) touch(e *entry, now time.Time) {
+	l.mux.Lock()
+	// The entry may have been removed since it was found.
+	if e.x.elem != nil {
+		e.x.used = now
+		l.list.MoveToFront(e.x.elem)
+	}
+	l.mux.Unlock()
+}
+
+func (l *lru) len() int {
+	if l == nil {
+		return 0
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.list.Len()
+}
+
+// over returns the least recently used entries beyond the first n.
+func (l *lru) over(n int) []*entry {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	var entries []*entry
+	for elem := l.list.Back(); elem != nil && l.list.Len()-len(entries) > n; elem = elem.Prev() {
+		entries = append(entries, elem.Value.(*entry))
+	}
+	return entries
+}
+
+// idle returns the entries last used before since, least recently used first.
+func (l *lru) idle(since time.Time) []*entry {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	var entries []*entry
+	for elem := l.list.Back(); elem != nil; elem = elem.Prev() {
+		e := elem.Value.(*entry)
+		if !e.x.used.Before(since) {
+			break
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// janitor periodically calls Expire on the mappers that have mappings that
+// may expire.  Its goroutine only runs while there are any.
+type janitor struct {
+	mux     sync.Mutex
+	mappers map[*Mapper]struct{}
+	running bool
+}
+
+var theJanitor janitor
+
+func (j *janitor) add(mapper *Mapper) {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	if j.mappers == nil {
+		j.mappers = make(map[*Mapper]struct{})
+	}
+	j.mappers[mapper] = struct{}{}
+	if !j.running {
+		j.running = true
+		go j.run()
+	}
+}
+
+func (j *janitor) remove(mapper *Mapper) {
+	j.mux.Lock()
+	delete(j.mappers, mapper)
+	j.mux.Unlock()
+}
+
+func (j *janitor) run() {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		j.mux.Lock()
+		if len(j.mappers) == 0 {
+			j.running = false
+			j.mux.Unlock()
+			return
+		}
+		mappers := make([]*Mapper, 0, len(j.mappers))
+		for mapper := range j.mappers {
+			mappers = append(mappers, mapper)
+		}
+		j.mux.Unlock()
+
+		for _, mapper := range mappers {
+			mapper.Expire()
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.jpap.org/mapper"
+)
+
+// fakeClock is a clock for WithClock that only moves when advanced.
+type fakeClock struct {
+	mux sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mux.Lock()
+	c.now = c.now.Add(d)
+	c.mux.Unlock()
+}
+
+// evictions records the evictions of a mapper.
+type evictions struct {
+	mux     sync.Mutex
+	reasons map[interface{}]mapper.EvictReason
+}
+
+func (ev *evictions) hook(k mapper.Key, v interface{}, reason mapper.EvictReason) {
+	ev.mux.Lock()
+	defer ev.mux.Unlock()
+	if ev.reasons == nil {
+		ev.reasons = make(map[interface{}]mapper.EvictReason)
+	}
+	ev.reasons[v] = reason
+}
+
+func (ev *evictions) reason(v interface{}) (mapper.EvictReason, bool) {
+	ev.mux.Lock()
+	defer ev.mux.Unlock()
+	reason, ok := ev.reasons[v]
+	return reason, ok
+}
+
+// wait waits for v to be evicted, possibly by the janitor, and returns why.
+func (ev *evictions) wait(t *testing.T, v interface{}) mapper.EvictReason {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if reason, ok := ev.reason(v); ok {
+			return reason
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v not evicted", v)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	clock := newFakeClock()
+	var ev evictions
+	var deleted atomic.Int32
+	m := mapper.New(mapper.WithClock(clock.Now), mapper.WithOnEvict(ev.hook), mapper.WithOnDelete(func(k mapper.Key, v interface{}) {
+		deleted.Add(1)
+	}))
+
+	short := m.MapValue("short", mapper.TTL(time.Second))
+	long := m.MapValue("long", mapper.TTL(time.Minute))
+	forever := m.MapValue("forever")
+	m.Delete(m.MapValue("deleted", mapper.TTL(time.Second)))
+
+	if n := m.Expire(); n != 0 {
+		t.Fatalf("Expire evicted %d mappings before their TTL", n)
+	}
+	clock.Advance(time.Second)
+	// The janitor may also expire the mapping, as soon as the clock moves.
+	m.Expire()
+	if m.Has(short) || !m.Has(long) || !m.Has(forever) {
+		t.Fatal("Expire evicted the wrong mappings")
+	}
+	if reason := ev.wait(t, "short"); reason != mapper.EvictExpired {
+		t.Fatalf("evicted with reason %v", reason)
+	}
+	// The deletion hooks are called after the eviction hooks.
+	for deleted.Load() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	m.Delete(long)
+	m.Delete(forever)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	clock := newFakeClock()
+	var ev evictions
+	m := mapper.New(mapper.WithClock(clock.Now), mapper.WithIdleTimeout(time.Minute))
+
+	used := m.MapValue("used")
+	idle := m.MapValue("idle", mapper.OnEvict(ev.hook))
+	clock.Advance(30 * time.Second)
+	m.Get(used)
+	clock.Advance(45 * time.Second)
+	m.Expire()
+	if m.Has(idle) || !m.Has(used) {
+		t.Fatal("Expire evicted the wrong mappings")
+	}
+	if reason := ev.wait(t, "idle"); reason != mapper.EvictIdle {
+		t.Fatalf("evicted with reason %v", reason)
+	}
+	m.Delete(used)
+}
+
+func TestCapacity(t *testing.T) {
+	var ev evictions
+	m := mapper.New(mapper.WithHandleTable(), mapper.WithCapacity(2), mapper.WithOnEvict(ev.hook))
+
+	a := m.MapValue("a")
+	b := m.MapValue("b")
+	m.Get(a)
+	c := m.MapValue("c")
+	if m.Len() != 2 || m.Has(b) || !m.Has(a) || !m.Has(c) {
+		t.Fatal("least recently used mapping not evicted")
+	}
+	if reason, _ := ev.reason("b"); reason != mapper.EvictCapacity {
+		t.Fatalf("evicted with reason %v", reason)
+	}
+	m.Clear()
+}
+
+func TestJanitor(t *testing.T) {
+	var ev evictions
+	m := mapper.New(mapper.WithOnEvict(ev.hook))
+	m.MapValue("value", mapper.TTL(time.Millisecond))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Len() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("janitor did not expire mapping")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	ev.wait(t, "value")
+}
@@ -1,3 +1,3 @@
 module go.jpap.org/mapper
 
-go 1.16
+go 1.23
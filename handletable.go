@@ -0,0 +1,156 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+// ptrBits is the size of a uintptr in bits.
+const ptrBits = 32 << (^uintptr(0) >> 63)
+
+// A handle-table counting key encodes a slot index in its low bits (above the
+// countingPointerBit), and the slot's generation in the remaining high bits:
+//
+//   [ generation | index | 1 ]
+//
+// On a 64-bit platform, that gives 2^32 slots with 2^31 generations each; on a
+// 32-bit platform, 2^16 slots with 2^15 generations each.  The tag of a Mapper
+// created WithTag takes the highest bits of the generation.
+const (
+	slotIndexBits = ptrBits / 2
+	slotGenBits   = ptrBits - 1 - slotIndexBits
+
+	slotIndexMask = 1<<slotIndexBits - 1
+	slotGenMask   = 1<<slotGenBits - 1
+)
+
+// handleTable allocates counting keys for a Mapper created WithHandleTable.
+// Lookups index the slots directly, and freed slots are reused with a new
+// generation so that stale keys are detected.  It is protected by Mapper.mux.
+type handleTable struct {
+	slots []slot
+	free  []uintptr // indexes of free slots
+	n     int       // number of used slots
+
+	// issued counts the keys allocated, each of which uses up one generation
+	// of a slot.
+	issued uint64
+
+	// format is the Mapper's.
+	format *keyFormat
+}
+
+type slot struct {
+	// gen is the generation of the key that may currently occupy the slot.  It
+	// is incremented each time the slot is freed.
+	gen uintptr
+	e   *entry // nil when the slot is free
+
+	// cleared is the generation of the slot when the Mapper was last cleared,
+	// so the keys of older generations were deleted no later than by Clear.
+	cleared uintptr
+}
+
+func (t *handleTable) slotKey(index, gen uintptr) Key {
+	return t.format.seal((gen<<slotIndexBits|index)<<1 | countingPointerBit)
+}
+
+func (t *handleTable) splitSlotKey(key Key) (index, gen uintptr) {
+	v := key.v &^ t.format.reserved() >> 1
+	return v & slotIndexMask, v >> slotIndexBits & t.genMask()
+}
+
+// genMask masks the generation bits that are not reserved by the format.
+func (t *handleTable) genMask() uintptr {
+	return slotGenMask &^ (t.format.reserved() >> (slotIndexBits + 1))
+}
+
+// alloc maps e to a free slot, and returns its key.
+func (t *handleTable) alloc(e *entry) Key {
+	var index uintptr
+	if n := len(t.free); n > 0 {
+		index = t.free[n-1]
+		t.free = t.free[:n-1]
+	} else {
+		index = uintptr(len(t.slots))
+		if index > slotIndexMask {
+			panic("key space exhausted")
+		}
+		t.slots = append(t.slots, slot{})
+	}
+	s := &t.slots[index]
+	s.e = e
+	t.n++
+	t.issued++
+	e.key = t.slotKey(index, s.gen)
+	return e.key
+}
+
+// keySpace returns the number of keys that alloc can still issue: one for
+// each generation of each slot, as a slot is retired after its last.
+func (t *handleTable) keySpace() uint64 {
+	return uint64(slotIndexMask+1)*uint64(t.genMask()+1) - t.issued
+}
+
+// get returns the entry mapped by key, or an error describing why there is
+// none: the key was never issued, its slot has since been freed, or it was
+// issued by another mapper.  Only a key equal to the one issued for the slot
+// resolves, so that one with different tag or check bits does not.
+func (t *handleTable) get(key Key) (*entry, error) {
+	index, gen := t.splitSlotKey(key)
+	if index < uintptr(len(t.slots)) && t.slots[index].e != nil && t.slots[index].e.key == key {
+		return t.slots[index].e, nil
+	}
+	if err := t.format.verify(key); err != nil {
+		return nil, err
+	}
+	if index < uintptr(len(t.slots)) {
+		s := &t.slots[index]
+		if gen < s.gen {
+			return nil, &UseAfterDeleteError{Key: key, Cleared: gen < s.cleared}
+		}
+	}
+	return nil, &NotMappedError{Key: key, Kind: CountingKey}
+}
+
+// remove frees the slot mapped by key, and returns its entry, or nil if key
+// is not mapped.
+func (t *handleTable) remove(key Key) *entry {
+	index, _ := t.splitSlotKey(key)
+	if index >= uintptr(len(t.slots)) {
+		return nil
+	}
+	s := &t.slots[index]
+	if s.e == nil || s.e.key != key {
+		return nil
+	}
+	e := s.e
+	t.release(index)
+	return e
+}
+
+// clear frees all slots, and returns the entries that were mapped.  It marks
+// the keys issued so far as cleared, including those already freed.
+func (t *handleTable) clear() []*entry {
+	var entries []*entry
+	for index := range t.slots {
+		s := &t.slots[index]
+		if e := s.e; e != nil {
+			entries = append(entries, e)
+			t.release(uintptr(index))
+		}
+		s.cleared = s.gen
+	}
+	return entries
+}
+
+func (t *handleTable) release(index uintptr) {
+	s := &t.slots[index]
+	s.e = nil
+	s.gen++
+	t.n--
+	// A slot whose generation would wrap around is retired, rather than risk a
+	// stale key resolving to a newer value.
+	if s.gen <= t.genMask() {
+		t.free = append(t.free, index)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"errors"
+	"testing"
+
+	"go.jpap.org/mapper"
+)
+
+func TestHandleTableReuse(t *testing.T) {
+	m := mapper.New(mapper.WithHandleTable())
+
+	old := m.MapValue("old")
+	if got := m.GetHandle(old.Handle()); got != "old" {
+		t.Fatalf("GetHandle returned %v", got)
+	}
+	m.Delete(old)
+
+	// The freed slot is reused, but with a new generation.
+	key := m.MapValue("new")
+	if key == old {
+		t.Fatalf("reused slot has the same key 0x%x", key.Handle())
+	}
+	if got := m.Get(key); got != "new" {
+		t.Fatalf("Get returned %v", got)
+	}
+
+	_, err := m.TryGet(old)
+	var uade *mapper.UseAfterDeleteError
+	if !errors.As(err, &uade) || uade.Key != old {
+		t.Fatalf("TryGet of stale key returned %v, want *UseAfterDeleteError", err)
+	}
+	if m.TryDelete(old) {
+		t.Fatal("TryDelete of stale key removed a mapping")
+	}
+	if got := m.Get(key); got != "new" {
+		t.Fatalf("Get after stale TryDelete returned %v", got)
+	}
+}
+
+// slotIndexBits is the number of bits of a handle-table key's slot index, above
+// which its generation starts.
+const slotIndexBits = 32 << (^uintptr(0) >> 63) / 2
+
+func TestHandleTableNotMapped(t *testing.T) {
+	m := mapper.New(mapper.WithHandleTable())
+	key := m.MapValue("value")
+
+	// A handle that was never issued: a far away slot, and a future
+	// generation of an existing slot.
+	for _, h := range []uintptr{key.Handle() + 1000, key.Handle() + 1<<(1+slotIndexBits)} {
+		_, err := m.TryGetHandle(h)
+		var nme *mapper.NotMappedError
+		var uade *mapper.UseAfterDeleteError
+		if !errors.As(err, &nme) || errors.As(err, &uade) {
+			t.Errorf("TryGetHandle(0x%x) returned %v, want *NotMappedError", h, err)
+		}
+	}
+}
+
+func TestHandleTableClear(t *testing.T) {
+	m := mapper.New(mapper.WithHandleTable())
+	keys := []mapper.Key{m.MapValue(1), m.MapValue(2)}
+	m.Delete(keys[1])
+	m.Clear()
+	for _, key := range keys {
+		_, err := m.TryGet(key)
+		var uade *mapper.UseAfterDeleteError
+		if !errors.As(err, &uade) || !uade.Cleared {
+			t.Fatalf("TryGet(0x%x) after Clear returned %v, want cleared", key.Handle(), err)
+		}
+	}
+	key := m.MapValue(3)
+	for _, old := range keys {
+		if key == old {
+			t.Fatalf("key 0x%x reissued after Clear", key.Handle())
+		}
+	}
+
+	// A key deleted since the Clear was not cleared.
+	m.Delete(key)
+	_, err := m.TryGet(key)
+	var uade *mapper.UseAfterDeleteError
+	if !errors.As(err, &uade) || uade.Cleared {
+		t.Fatalf("TryGet of key deleted after Clear returned %v", err)
+	}
+}
+
+func BenchmarkHandleTableGetParallel(b *testing.B) {
+	m := mapper.New(mapper.WithHandleTable())
+	benchGetParallel(b, m.MapValue, m.Get)
+}
@@ -0,0 +1,102 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/maphash"
+)
+
+// keyFormat describes the bits that a Mapper created WithTag or
+// WithHardenedHandles reserves at the top of the counting keys it issues,
+// above the counter, or the generation of a handle-table key:
+//
+//   [ tag | check | counter | 1 ]
+//
+// WithRandomKeys also scrambles the counter.  The format is set up by New, and
+// immutable afterwards.
+type keyFormat struct {
+	tagBits   int
+	checkBits int
+	seed      uint64 // set by WithRandomKeys
+	scrambler *scrambler
+
+	tag       uintptr // within tagMask
+	tagMask   uintptr
+	checkMask uintptr
+}
+
+// init computes the masks of a format whose widths, and tag number, have been
+// set by the options.
+func (f *keyFormat) init() {
+	if f.tagBits+f.checkBits >= slotGenBits {
+		panic(fmt.Errorf("tag and check bits too wide: %d bits", f.tagBits+f.checkBits))
+	}
+	if f.tagBits > 0 {
+		f.tagMask = ^uintptr(0) << (ptrBits - f.tagBits)
+		f.tag <<= ptrBits - f.tagBits
+	}
+	if f.checkBits > 0 {
+		f.checkMask = ^uintptr(0) << (ptrBits - f.tagBits - f.checkBits) &^ f.tagMask
+	}
+	if f.seed != 0 {
+		f.scrambler = newScrambler(ptrBits-1-f.tagBits-f.checkBits, f.seed)
+	}
+}
+
+// reserved masks the bits of the tag and check bits.
+func (f *keyFormat) reserved() uintptr {
+	return f.tagMask | f.checkMask
+}
+
+// seal adds the tag and check bits to the counter or slot bits v.
+func (f *keyFormat) seal(v uintptr) Key {
+	v |= f.tag
+	if f.checkMask != 0 {
+		v |= checkBits(v) & f.checkMask
+	}
+	return Key{v}
+}
+
+// counterKey returns the counting key for next, an even counter value.
+func (f *keyFormat) counterKey(next uintptr) Key {
+	if f.scrambler != nil {
+		next = f.scrambler.forward(next>>1) << 1
+	}
+	return f.seal(next | countingPointerBit)
+}
+
+// keyCounter returns the counter value of a counting key, as passed to
+// counterKey.
+func (f *keyFormat) keyCounter(key Key) uintptr {
+	counter := key.v &^ f.reserved() &^ countingPointerBit
+	if f.scrambler != nil {
+		counter = f.scrambler.backward(counter>>1) << 1
+	}
+	return counter
+}
+
+// verify returns an *InvalidHandleError if the check bits of a counting key
+// are wrong, or a *WrongMapperError if its tag is not the mapper's.
+func (f *keyFormat) verify(key Key) error {
+	if f.checkMask != 0 && key.v&f.checkMask != checkBits(key.v&^f.checkMask)&f.checkMask {
+		return &InvalidHandleError{Key: key}
+	}
+	if key.v&f.tagMask != f.tag {
+		return &WrongMapperError{Key: key}
+	}
+	return nil
+}
+
+// handleSecret is the per-process secret that keys the check bits.
+var handleSecret = maphash.MakeSeed()
+
+// checkBits returns a keyed hash of v, from which the check bits are taken.
+func checkBits(v uintptr) uintptr {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(v))
+	return uintptr(maphash.Bytes(handleSecret, b[:]))
+}
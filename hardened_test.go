@@ -0,0 +1,70 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"errors"
+	"testing"
+
+	"go.jpap.org/mapper"
+)
+
+func TestHardenedHandles(t *testing.T) {
+	for _, opts := range [][]mapper.Option{
+		{mapper.WithHardenedHandles(24)},
+		{mapper.WithHardenedHandles(24), mapper.WithHandleTable()},
+		{mapper.WithHardenedHandles(20), mapper.WithTag(4), mapper.WithReadMostly()},
+	} {
+		m := mapper.New(opts...)
+		key := m.MapValue("value")
+		if got := m.GetHandle(key.Handle()); got != "value" {
+			t.Fatalf("GetHandle returned %v", got)
+		}
+
+		// Values that C code might have scribbled over the handle.
+		for _, forged := range []uintptr{1, 3, key.Handle() + 2, key.Handle() ^ 1<<20} {
+			_, err := m.TryGetHandle(forged)
+			var ihe *mapper.InvalidHandleError
+			if !errors.As(err, &ihe) || ihe.Key.Handle() != forged {
+				t.Fatalf("TryGetHandle(0x%x) returned %v, want *InvalidHandleError", forged, err)
+			}
+			var nme *mapper.NotMappedError
+			if !errors.As(err, &nme) || nme.Key.Handle() != forged {
+				t.Fatalf("InvalidHandleError for 0x%x does not match *NotMappedError", forged)
+			}
+			if m.TryDeleteHandle(forged) {
+				t.Fatalf("TryDeleteHandle(0x%x) returned true", forged)
+			}
+		}
+
+		m.Delete(key)
+		_, err := m.TryGet(key)
+		var uade *mapper.UseAfterDeleteError
+		if !errors.As(err, &uade) {
+			t.Fatalf("TryGet of deleted key returned %v", err)
+		}
+	}
+}
+
+func TestHardenedHandlesWrongMapper(t *testing.T) {
+	a := mapper.New(mapper.WithTag(4), mapper.WithHardenedHandles(16))
+	b := mapper.New(mapper.WithTag(4), mapper.WithHardenedHandles(16))
+	key := a.MapValue("a")
+	_, err := b.TryGet(key)
+	var wme *mapper.WrongMapperError
+	if !errors.As(err, &wme) {
+		t.Fatalf("TryGet on other mapper returned %v, want *WrongMapperError", err)
+	}
+	a.Delete(key)
+}
+
+func TestHardenedHandlesWidth(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("New with too many reserved bits did not panic")
+		}
+	}()
+	mapper.New(mapper.WithTag(16), mapper.WithHardenedHandles(16))
+}
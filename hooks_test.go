@@ -0,0 +1,67 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"testing"
+	"unsafe"
+
+	"go.jpap.org/mapper"
+)
+
+func TestOnDelete(t *testing.T) {
+	deleted := make(map[interface{}]int)
+	m := mapper.New(mapper.WithOnDelete(func(k mapper.Key, v interface{}) {
+		deleted[v]++
+	}))
+
+	buf := make([]uint64, 2)
+	ptr := unsafe.Pointer(&buf[0])
+
+	m.Delete(m.MapValue("delete"))
+	m.DeleteHandle(m.MapValue("handle").Handle())
+	m.MapPtrPair(ptr, "ptr")
+	m.DeletePtr(ptr)
+	m.Release(m.MapValue("release", mapper.RefCounted()))
+	m.DeleteAndWait(m.MapValue("wait"))
+	m.MapPtrPair(ptr, "replaced")
+	m.MapPtrPair(ptr, "clear")
+	m.MapValue("clear")
+	m.Clear()
+
+	// Deleting again must not call the hooks again.
+	m.DeletePtr(ptr)
+	m.Clear()
+
+	for v, n := range map[interface{}]int{
+		"delete": 1, "handle": 1, "ptr": 1, "release": 1, "wait": 1, "replaced": 1, "clear": 2,
+	} {
+		if deleted[v] != n {
+			t.Errorf("hook called %d times for %q, want %d", deleted[v], v, n)
+		}
+	}
+}
+
+func TestOnDeleteReentrant(t *testing.T) {
+	var m mapper.Mapper
+	var order []string
+
+	related := m.MapValue("related", mapper.OnDelete(func(k mapper.Key, v interface{}) {
+		order = append(order, "related")
+	}))
+	key := m.MapValue("main", mapper.OnDelete(func(k mapper.Key, v interface{}) {
+		order = append(order, "main")
+		// The hook may call back into the mapper.
+		m.Delete(related)
+	}))
+
+	m.Delete(key)
+	if len(order) != 2 || order[0] != "main" || order[1] != "related" {
+		t.Fatalf("hooks called in order %v", order)
+	}
+	if _, ok := m.Lookup(related); ok {
+		t.Fatal("related mapping not deleted by hook")
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+import "iter"
+
+// Len returns the number of live mappings.
+func (mapper *Mapper) Len() int {
+	if mapper.readMostly && mapper.table == nil {
+		m, _ := mapper.snapshot.Load().(map[Key]*entry)
+		return len(m)
+	}
+	mapper.mux.RLock()
+	n := len(mapper.m)
+	if mapper.table != nil {
+		n += mapper.table.n
+	}
+	mapper.mux.RUnlock()
+	return n
+}
+
+// Has reports whether the key is mapped.  Unlike Lookup, a key that is not
+// mapped is not recorded as a miss.
+func (mapper *Mapper) Has(key Key) bool {
+	if mapper.readMostly && !mapper.inTable(key) {
+		m, _ := mapper.snapshot.Load().(map[Key]*entry)
+		_, ok := m[key]
+		return ok
+	}
+	mapper.mux.RLock()
+	defer mapper.mux.RUnlock()
+	if mapper.inTable(key) {
+		_, err := mapper.table.get(key)
+		return err == nil
+	}
+	_, ok := mapper.m[key]
+	return ok
+}
+
+// Range calls fn for each mapping, in no particular order, until fn returns
+// false.
+//
+// Range visits the mappings that were live when it was called: it takes a
+// snapshot, and calls fn without the mapper locked.  So fn may use the
+// mapper, e.g. to delete the mapping it was called with, but mappings created
+// or deleted while Range runs may or may not be visited.
+func (mapper *Mapper) Range(fn func(key Key, goValue interface{}) bool) {
+	mapper.mux.RLock()
+	entries := mapper.entriesLocked()
+	mapper.mux.RUnlock()
+	for _, e := range entries {
+		if !fn(e.key, e.value) {
+			return
+		}
+	}
+}
+
+// All returns an iterator over the mappings, with the same semantics as
+// Range.
+func (mapper *Mapper) All() iter.Seq2[Key, any] {
+	return mapper.Range
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"testing"
+
+	"go.jpap.org/mapper"
+)
+
+func TestIntrospection(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		m    *mapper.Mapper
+	}{
+		{"map", mapper.New()},
+		{"readMostly", mapper.New(mapper.WithReadMostly())},
+		{"table", mapper.New(mapper.WithHandleTable())},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := tc.m
+
+			want := make(map[mapper.Key]interface{})
+			for i := 0; i < 10; i++ {
+				want[m.MapValue(i)] = i
+			}
+			if n := m.Len(); n != len(want) {
+				t.Fatalf("Len returned %d, want %d", n, len(want))
+			}
+
+			got := make(map[mapper.Key]interface{})
+			for key, v := range m.All() {
+				if !m.Has(key) {
+					t.Errorf("Has(0x%x) returned false", key.Handle())
+				}
+				got[key] = v
+			}
+			if len(got) != len(want) {
+				t.Fatalf("All visited %d mappings, want %d", len(got), len(want))
+			}
+			for key, v := range want {
+				if got[key] != v {
+					t.Errorf("All yielded %v for 0x%x, want %v", got[key], key.Handle(), v)
+				}
+			}
+
+			// Range may delete the mappings it visits, and stop early.
+			visited := 0
+			m.Range(func(key mapper.Key, v interface{}) bool {
+				m.Delete(key)
+				visited++
+				return visited < 5
+			})
+			if visited != 5 || m.Len() != 5 {
+				t.Fatalf("Range visited %d mappings, leaving %d", visited, m.Len())
+			}
+			m.Clear()
+			if m.Len() != 0 {
+				t.Fatalf("Len after Clear returned %d", m.Len())
+			}
+		})
+	}
+}
+
+func TestShardedIntrospection(t *testing.T) {
+	sm := mapper.NewShardedMapper(4)
+	for i := 0; i < 10; i++ {
+		sm.MapValue(i)
+	}
+	n := 0
+	for range sm.All() {
+		n++
+		if n == 3 {
+			break
+		}
+	}
+	if n != 3 || sm.Len() != 10 {
+		t.Fatalf("All visited %d mappings, Len returned %d", n, sm.Len())
+	}
+}
@@ -5,20 +5,102 @@
 package mapper
 
 import (
+	"container/list"
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
 // Mapper maps between Key and Go values.
+//
+// The zero Mapper is ready to use; New creates a Mapper with options.
 type Mapper struct {
 	mux sync.RWMutex
-	m   map[Key]interface{}
+	m   map[Key]*entry
+
+	// name is set by NewNamed.
+	name string
+
+	// readMostly is set by WithReadMostly.  In that mode, m is never modified
+	// once published to snapshot; writers replace it with a modified copy.
+	readMostly bool
+	snapshot   atomic.Value // map[Key]*entry
+
+	// table allocates and maps counting keys when set by WithHandleTable.
+	table *handleTable
+
+	// onDelete is set by WithOnDelete.
+	onDelete func(Key, interface{})
+
+	// debug is set by WithDebug.
+	debug bool
+
+	// profile is set by WithProfile.
+	profile bool
+
+	// quarantine is set by WithQuarantine.
+	quarantine *quarantine
+
+	// recorder is set by WithFlightRecorder.  op stamps the operations it
+	// records while mux is write-locked; it is protected by mux.
+	recorder *recorder
+	op       stamp
+
+	// clock is set by WithClock.
+	clock func() time.Time
+
+	// onEvict, idleTimeout and capacity are set by WithOnEvict,
+	// WithIdleTimeout and WithCapacity.  lru is created by the latter two.
+	onEvict     func(Key, interface{}, EvictReason)
+	idleTimeout time.Duration
+	capacity    int
+	lru         *lru
+
+	// deadlines holds the mappings created with a TTL, and expiring is set
+	// while the mapper is registered with the janitor.  Both are protected by
+	// mux.
+	deadlines deadlineHeap
+	expiring  bool
+
+	// raw maps the odd pointers mapped by MapRawPtrPair to the counting keys
+	// of their mappings.  It is protected by mux.
+	raw map[uintptr]Key
+
+	// ranges holds the mappings created by MapRange, sorted by address.  It
+	// is protected by mux.
+	ranges []*entry
+
+	// canceled keeps tombstones for the mappings deleted when the context of
+	// MapValueContext is done.  It is created on first use, and loaded
+	// without mux by the read-mostly find; its tombstones are protected by
+	// mux.
+	canceled atomic.Pointer[quarantine]
+
+	// Counters reported by Stats.  The traffic counters are kept when
+	// trafficStats is set by WithTrafficStats, and are updated without mux;
+	// the others are protected by it.
+	trafficStats bool
+	traffic      atomic.Pointer[traffic]
+	maps         uint64
+	deletes      uint64
+	highWater    int
 
 	// atomicKey is a sizeof(pointer)/2 value (lower bit is reserved) that is
-	// incremented for each new Key "allocation".
+	// incremented for each new Key "allocation".  It is only modified with mux
+	// held, but may be read atomically without it.  It is never reset, so that
+	// a counting key is never issued twice.
 	atomicKey uintptr
+
+	// format is set up by WithTag, WithHardenedHandles and WithRandomKeys, and
+	// is the zero format otherwise.
+	format keyFormat
+
+	// epochKey is the value of atomicKey at the last Clear: counting keys at or
+	// below it were issued before the Clear.  Like atomicKey, it is modified
+	// with mux held.
+	epochKey uintptr
 }
 
 // Key is an opaque token used to map onto Go values.
@@ -32,6 +114,35 @@ type Key struct {
 // aligned.
 const countingPointerBit = 1
 
+// KeyKind describes how a Key was obtained.
+type KeyKind int
+
+const (
+	// PointerKey is a Key obtained from a cgo pointer, via KeyFromPtr or
+	// MapPtrPair.
+	PointerKey KeyKind = iota
+	// CountingKey is a synthetic Key allocated by MapValue.
+	CountingKey
+)
+
+func (kind KeyKind) String() string {
+	switch kind {
+	case PointerKey:
+		return "pointer key"
+	case CountingKey:
+		return "counting key"
+	}
+	return fmt.Sprintf("KeyKind(%d)", int(kind))
+}
+
+// Kind returns the kind of the key.
+func (k Key) Kind() KeyKind {
+	if k.v&countingPointerBit != 0 {
+		return CountingKey
+	}
+	return PointerKey
+}
+
 // Handle returns an opaque "pointer" value that be passed to a C function via
 // cgo.  The returned value is pointer-sized, but should never be used as a
 // pointer because it may NOT be a valid address in the process' address space.
@@ -79,20 +190,22 @@ func KeyFromHandle(handle uintptr) Key {
 }
 
 // G is the global mapper... for users who don't care about lock contention.
-// For those that do, we recommend a separate Mapper instance.
+// For those that do, we recommend a separate Mapper instance, or a
+// ShardedMapper.
 var G Mapper
 
-// MapPair creates a mapping between the provided Key and Go values.
-func (mapper *Mapper) MapPair(key Key, goValue interface{}) {
-	mapper.doMap(key, goValue)
+// MapPair creates a mapping between the provided Key and Go values.  An
+// existing mapping for the key is replaced, and is deleted as if by Delete.
+func (mapper *Mapper) MapPair(key Key, goValue interface{}, opts ...MapOption) {
+	mapper.doMap(key, mapper.newEntry(goValue, opts))
 }
 
 // MapPtrPair is like MapPair, but maps from the given cgo pointer, and returns
 // the associated Key.  This method is a convenience wrapper around KeyFromPtr
 // and MapPair.
-func (mapper *Mapper) MapPtrPair(ptr unsafe.Pointer, goValue interface{}) Key {
+func (mapper *Mapper) MapPtrPair(ptr unsafe.Pointer, goValue interface{}, opts ...MapOption) Key {
 	key := KeyFromPtr(ptr)
-	mapper.MapPair(key, goValue)
+	mapper.MapPair(key, goValue, opts...)
 	return key
 }
 
@@ -103,23 +216,53 @@ func (mapper *Mapper) MapPtrPair(ptr unsafe.Pointer, goValue interface{}) Key {
 // unlikely ever run out during the lifetime of a program... but if it does, we
 // panic.  To avoid running out of space on a 32-bit platform (where
 // 2,147,483,648 mappings are possible), use MapPtrPair instead.
-func (mapper *Mapper) MapValue(goValue interface{}) Key {
-	key := Key{atomic.AddUintptr(&mapper.atomicKey, 2) | countingPointerBit}
-	// Crash on wrap-around
-	if key.v == 0 {
+func (mapper *Mapper) MapValue(goValue interface{}, opts ...MapOption) Key {
+	return mapper.mapValue(mapper.newEntry(goValue, opts))
+}
+
+// mapValue maps e to a new counting key.
+func (mapper *Mapper) mapValue(e *entry) Key {
+	key, full := mapper.allocValue(e)
+	mapper.evictedAll(full)
+	return key
+}
+
+// allocValue maps e to a new counting key, and returns it with the mappings
+// evicted to make room for it.
+func (mapper *Mapper) allocValue(e *entry) (Key, []*entry) {
+	mapper.lock()
+	defer mapper.mux.Unlock()
+	return mapper.allocValueLocked(e)
+}
+
+// allocValueLocked is like allocValue, but requires mux to be held.
+func (mapper *Mapper) allocValueLocked(e *entry) (Key, []*entry) {
+	if mapper.table != nil {
+		key := mapper.table.alloc(e)
+		mapper.profileAdd(e)
+		mapper.countMapLocked()
+		mapper.record(OpMap, key)
+		return key, mapper.trackLocked(e)
+	}
+	// The key is allocated with mux held, so that it can't race with Clear.
+	next := mapper.atomicKey + 2
+	// Crash on wrap-around, or into the tag
+	if next == 0 || next&mapper.format.reserved() != 0 {
 		panic("key space exhausted")
 	}
-	mapper.doMap(key, goValue)
-	return key
+	key := mapper.format.counterKey(next)
+	_, full := mapper.mapLocked(key, e)
+	atomic.StoreUintptr(&mapper.atomicKey, next)
+	return key, full
 }
 
-// Get retrieves the Go value from the given key.
+// Get retrieves the Go value from the given key.  Get panics with the error
+// from TryGet if the key is not mapped; see TryGet and Lookup for
+// non-panicking alternatives.
 func (mapper *Mapper) Get(key Key) (goValue interface{}) {
-	mapper.mux.RLock()
-	goValue, ok := mapper.m[key]
-	mapper.mux.RUnlock()
-	if !ok {
-		panic(fmt.Errorf("key not mapped: 0x%x", key))
+	goValue, err := mapper.TryGet(key)
+	if err != nil {
+		panic(err)
 	}
 	return
 }
@@ -137,11 +280,59 @@ func (mapper *Mapper) GetHandle(handle uintptr) (goValue interface{}) {
 	return mapper.Get(key)
 }
 
-// Delete an existing mapping via the given key.
+// TryGet is like Get, but returns an error instead of panicking when the key
+// is not mapped.  The error is a *NotMappedError, or a *UseAfterDeleteError if
+// the mapper can tell that the key was mapped, but has since been deleted.
+func (mapper *Mapper) TryGet(key Key) (goValue interface{}, err error) {
+	e, err := mapper.find(key)
+	if err != nil {
+		return nil, err
+	}
+	return e.value, nil
+}
+
+// TryGetPtr calls TryGet after first converting the given cgo pointer to a
+// Key.
+func (mapper *Mapper) TryGetPtr(ptr unsafe.Pointer) (goValue interface{}, err error) {
+	key := Key{uintptr(ptr)}
+	return mapper.TryGet(key)
+}
+
+// TryGetHandle calls TryGet after first converting the given handle to a Key.
+func (mapper *Mapper) TryGetHandle(handle uintptr) (goValue interface{}, err error) {
+	key := KeyFromHandle(handle)
+	return mapper.TryGet(key)
+}
+
+// Lookup retrieves the Go value from the given key, and reports whether the
+// key was mapped.
+func (mapper *Mapper) Lookup(key Key) (goValue interface{}, ok bool) {
+	e, err := mapper.find(key)
+	if err != nil {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// LookupPtr calls Lookup after first converting the given cgo pointer to a
+// Key.
+func (mapper *Mapper) LookupPtr(ptr unsafe.Pointer) (goValue interface{}, ok bool) {
+	key := Key{uintptr(ptr)}
+	return mapper.Lookup(key)
+}
+
+// LookupHandle calls Lookup after first converting the given handle to a Key.
+func (mapper *Mapper) LookupHandle(handle uintptr) (goValue interface{}, ok bool) {
+	key := KeyFromHandle(handle)
+	return mapper.Lookup(key)
+}
+
+// Delete an existing mapping via the given key.  Deleting a key that is not
+// mapped is a no-op; use TryDelete to find out if a mapping was removed.
+//
+// A reference-counted mapping is deleted regardless of its count.
 func (mapper *Mapper) Delete(key Key) {
-	mapper.mux.Lock()
-	delete(mapper.m, key)
-	mapper.mux.Unlock()
+	mapper.TryDelete(key)
 }
 
 // DeletePtr deletes an existing mapping from the given cgo pointer.
@@ -157,19 +348,372 @@ func (mapper *Mapper) DeleteHandle(handle uintptr) {
 	mapper.Delete(key)
 }
 
-// Clear all mappings.
+// TryDelete deletes an existing mapping via the given key, and reports
+// whether a mapping was removed.
+func (mapper *Mapper) TryDelete(key Key) (deleted bool) {
+	mapper.lock()
+	e := mapper.removeLocked(key)
+	mapper.mux.Unlock()
+	if e == nil {
+		return false
+	}
+	mapper.deleted(e)
+	return true
+}
+
+// TryDeletePtr calls TryDelete after first converting the given cgo pointer to
+// a Key.
+func (mapper *Mapper) TryDeletePtr(ptr unsafe.Pointer) (deleted bool) {
+	// We don't use KeyFromPtr, which panics on an unaligned ptr.
+	key := Key{uintptr(ptr)}
+	return mapper.TryDelete(key)
+}
+
+// TryDeleteHandle calls TryDelete after first converting the given handle to a
+// Key.
+func (mapper *Mapper) TryDeleteHandle(handle uintptr) (deleted bool) {
+	key := Key{handle}
+	return mapper.TryDelete(key)
+}
+
+// Clear all mappings.  The deletion hooks of each mapping are called once
+// all mappings have been removed, in no particular order.
+//
+// Clear starts a new epoch: counting keys issued before the Clear are never
+// issued again, and are reported by TryGet as a *UseAfterDeleteError.
 func (mapper *Mapper) Clear() {
-	mapper.mux.Lock()
-	mapper.m = nil
-	mapper.atomicKey = 0
+	mapper.lock()
+	entries := mapper.clearLocked()
 	mapper.mux.Unlock()
+	for _, e := range entries {
+		mapper.deleted(e)
+	}
 }
 
-func (mapper *Mapper) doMap(key Key, goValue interface{}) {
-	mapper.mux.Lock()
-	if mapper.m == nil {
-		mapper.m = make(map[Key]interface{})
+// entry is a mapped Go value, together with its bookkeeping.  The value is
+// never modified once mapped, so that it can be read without a lock
+// WithReadMostly; the other fields are protected by Mapper.mux.
+type entry struct {
+	key   Key
+	value interface{}
+
+	// x holds the state of the options and features that the mapping uses,
+	// or is nil for a plain mapping, so that it stays small.  It is
+	// allocated by extra before the entry is mapped.
+	x *entryExtra
+
+	// borrows counts the outstanding Borrow calls, once there has been one.
+	// Borrow adds to it with mux held, and only while the entry is mapped.
+	borrows atomic.Pointer[sync.WaitGroup]
+}
+
+// entryExtra is the state of an entry that only some mappings need.
+type entryExtra struct {
+	// refs is the reference count of a mapping created RefCounted, or zero
+	// for a mapping that is not reference counted.
+	refs      int
+	onRelease func(Key, interface{})
+
+	onDelete func(Key, interface{})
+
+	// ttl is set by the TTL option, and onEvict by OnEvict.  A mapping with a
+	// TTL is in Mapper.deadlines at deadlineIndex while it is mapped.
+	ttl           time.Duration
+	deadline      time.Time
+	deadlineIndex int
+	onEvict       func(Key, interface{}, EvictReason)
+
+	// rawPtr is the odd pointer of a mapping created by MapRawPtrPair.
+	rawPtr uintptr
+
+	// [start, end) is the address range of a mapping created by MapRange, or
+	// empty.
+	start, end uintptr
+
+	// used and elem track the mapping in Mapper.lru, protected by its lock.
+	used time.Time
+	elem *list.Element
+
+	// created and stack record when and where the mapping was created, in
+	// debug mode.
+	created time.Time
+	stack   []uintptr
+
+	// stop unregisters the context callback of a mapping created by
+	// MapValueContext.  It is set with mux held, and only while the entry is
+	// mapped.
+	stop func() bool
+}
+
+// extra returns e.x, allocating it first if need be.  It must not be called
+// once e is mapped.
+func (e *entry) extra() *entryExtra {
+	if e.x == nil {
+		e.x = new(entryExtra)
+	}
+	return e.x
+}
+
+func (mapper *Mapper) newEntry(goValue interface{}, opts []MapOption) *entry {
+	e := &entry{value: goValue}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if mapper.lru != nil {
+		e.extra()
+	}
+	if mapper.debugging() {
+		x := e.extra()
+		x.created = mapper.now()
+		x.stack = callers()
+	}
+	return e
+}
+
+func (mapper *Mapper) doMap(key Key, e *entry) {
+	old, full := mapper.mapPair(key, e)
+	if old != nil {
+		mapper.deleted(old)
+	}
+	mapper.evictedAll(full)
+}
+
+// mapPair maps key to e, and returns the entry it replaced, if any, and the
+// entries evicted to make room for it, without calling their hooks.
+func (mapper *Mapper) mapPair(key Key, e *entry) (old *entry, full []*entry) {
+	if mapper.table != nil && key.Kind() == CountingKey {
+		panic(fmt.Errorf("counting key 0x%x must be allocated by MapValue", key.v))
+	}
+	mapper.lock()
+	defer mapper.mux.Unlock()
+	return mapper.mapLocked(key, e)
+}
+
+// mapLocked maps key to e, and returns the entry it replaced, if any, and the
+// entries evicted to make room for it.
+func (mapper *Mapper) mapLocked(key Key, e *entry) (old *entry, full []*entry) {
+	e.key = key
+	old = mapper.m[key]
+	if mapper.quarantine != nil {
+		mapper.quarantine.exhume(key)
+	}
+	mapper.mutableLocked()
+	mapper.m[key] = e
+	mapper.publishLocked()
+	if old != nil {
+		mapper.deletes++
+		mapper.profileRemove(old)
+		mapper.untrackLocked(old)
+		mapper.unindexLocked(old)
+	}
+	mapper.profileAdd(e)
+	mapper.countMapLocked()
+	mapper.record(OpMap, key)
+	return old, mapper.trackLocked(e)
+}
+
+// deleted calls the deletion hooks of an entry that has been removed.  It must
+// be called exactly once per removed entry, without mux held, so that hooks
+// may use the mapper.
+func (mapper *Mapper) deleted(e *entry) {
+	if x := e.x; x != nil {
+		if x.stop != nil {
+			x.stop()
+		}
+		if x.onDelete != nil {
+			x.onDelete(e.key, e.value)
+		}
+	}
+	if mapper.onDelete != nil {
+		mapper.onDelete(e.key, e.value)
+	}
+}
+
+// find returns the entry mapped by key, or an error describing why there is
+// none.
+func (mapper *Mapper) find(key Key) (*entry, error) {
+	if mapper.readMostly && !mapper.inTable(key) {
+		m, _ := mapper.snapshot.Load().(map[Key]*entry)
+		if e, ok := m[key]; ok {
+			mapper.countHit()
+			mapper.touch(e)
+			return e, nil
+		}
+		if mapper.quarantine == nil && mapper.canceled.Load() == nil {
+			return nil, mapper.miss(key, mapper.notMapped(key))
+		}
+	}
+	mapper.mux.RLock()
+	e, err := mapper.findLocked(key)
+	mapper.mux.RUnlock()
+	if err != nil {
+		return nil, mapper.miss(key, err)
+	}
+	return e, nil
+}
+
+// findLocked is like find, but requires mux to be held.  The error for a key
+// that is not mapped must be passed to miss once mux is released.
+func (mapper *Mapper) findLocked(key Key) (*entry, error) {
+	var err error
+	if mapper.inTable(key) {
+		var e *entry
+		if e, err = mapper.table.get(key); err == nil {
+			mapper.countHit()
+			mapper.touch(e)
+			return e, nil
+		}
+	} else if e, ok := mapper.m[key]; ok {
+		mapper.countHit()
+		mapper.touch(e)
+		return e, nil
+	} else {
+		err = mapper.notMapped(key)
+	}
+	return nil, mapper.useAfterDeleteLocked(key, err)
+}
+
+// mappedLocked reports whether e.key is still mapped to e.
+func (mapper *Mapper) mappedLocked(e *entry) bool {
+	if mapper.inTable(e.key) {
+		current, _ := mapper.table.get(e.key)
+		return current == e
+	}
+	return mapper.m[e.key] == e
+}
+
+// removeEntry removes the mapping for e.key, but only if it is still mapped to
+// e, and then calls the deletion hooks.  It reports whether e was removed.  A
+// non-nil cause records that the mapping was canceled, see MapValueContext.
+func (mapper *Mapper) removeEntry(e *entry, cause error) bool {
+	mapper.lock()
+	if !mapper.mappedLocked(e) {
+		mapper.mux.Unlock()
+		return false
+	}
+	mapper.removeLocked(e.key)
+	if cause != nil {
+		mapper.buryCanceledLocked(e.key, cause)
 	}
-	mapper.m[key] = goValue
 	mapper.mux.Unlock()
+	mapper.deleted(e)
+	return true
+}
+
+// removeLocked removes the mapping for key, and returns its entry, or nil if
+// there is none.
+func (mapper *Mapper) removeLocked(key Key) *entry {
+	if mapper.inTable(key) {
+		e := mapper.table.remove(key)
+		if e != nil {
+			mapper.deletes++
+			mapper.profileRemove(e)
+			mapper.untrackLocked(e)
+			mapper.unindexLocked(e)
+			mapper.buryLocked(key, false)
+			mapper.record(OpDelete, key)
+		}
+		return e
+	}
+	e, ok := mapper.m[key]
+	if !ok {
+		return nil
+	}
+	mapper.mutableLocked()
+	delete(mapper.m, key)
+	mapper.publishLocked()
+	mapper.deletes++
+	mapper.profileRemove(e)
+	mapper.untrackLocked(e)
+	mapper.unindexLocked(e)
+	mapper.buryLocked(key, false)
+	mapper.record(OpDelete, key)
+	return e
+}
+
+// clearLocked removes all mappings, and returns their entries.
+func (mapper *Mapper) clearLocked() []*entry {
+	var entries []*entry
+	for _, e := range mapper.m {
+		entries = append(entries, e)
+	}
+	mapper.m = nil
+	mapper.raw = nil
+	mapper.ranges = nil
+	atomic.StoreUintptr(&mapper.epochKey, mapper.atomicKey)
+	mapper.publishLocked()
+	if mapper.table != nil {
+		entries = append(entries, mapper.table.clear()...)
+	}
+	mapper.deletes += uint64(len(entries))
+	for _, e := range entries {
+		mapper.profileRemove(e)
+		mapper.untrackLocked(e)
+		mapper.unindexLocked(e)
+		mapper.buryLocked(e.key, true)
+	}
+	mapper.record(OpClear, Key{})
+	return entries
+}
+
+// entriesLocked returns all mapped entries.
+func (mapper *Mapper) entriesLocked() []*entry {
+	entries := make([]*entry, 0, len(mapper.m))
+	for _, e := range mapper.m {
+		entries = append(entries, e)
+	}
+	if mapper.table != nil {
+		for _, s := range mapper.table.slots {
+			if s.e != nil {
+				entries = append(entries, s.e)
+			}
+		}
+	}
+	return entries
+}
+
+// inTable reports whether key belongs to the handle table.
+func (mapper *Mapper) inTable(key Key) bool {
+	return mapper.table != nil && key.Kind() == CountingKey
+}
+
+// notMapped returns the error for a key that is missing from mapper.m.
+// Counting keys are issued in sequence, so one that is not beyond the last
+// issued key must have been deleted.
+func (mapper *Mapper) notMapped(key Key) error {
+	if key.Kind() == CountingKey {
+		if err := mapper.format.verify(key); err != nil {
+			return err
+		}
+		counter := mapper.format.keyCounter(key)
+		if counter != 0 && counter <= atomic.LoadUintptr(&mapper.atomicKey) {
+			cleared := counter <= atomic.LoadUintptr(&mapper.epochKey)
+			return &UseAfterDeleteError{Key: key, Cleared: cleared}
+		}
+	}
+	return &NotMappedError{Key: key, Kind: key.Kind()}
+}
+
+// mutableLocked prepares mapper.m for modification.  In read-mostly mode, the
+// published map is replaced by a private copy that is published again by
+// publishLocked.
+func (mapper *Mapper) mutableLocked() {
+	if !mapper.readMostly {
+		if mapper.m == nil {
+			mapper.m = make(map[Key]*entry)
+		}
+		return
+	}
+	m := make(map[Key]*entry, len(mapper.m)+1)
+	for k, e := range mapper.m {
+		m[k] = e
+	}
+	mapper.m = m
+}
+
+// publishLocked makes the modified mapper.m visible to lock-free readers.
+func (mapper *Mapper) publishLocked() {
+	if mapper.readMostly {
+		mapper.snapshot.Store(mapper.m)
+	}
 }
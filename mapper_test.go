@@ -5,11 +5,20 @@
 package mapper_test
 
 import (
+	"errors"
+	"sync"
 	"testing"
+	"unsafe"
 
+	"go.jpap.org/mapper"
 	itest "go.jpap.org/mapper/internal/testing"
+	"go.jpap.org/mapper/mappertest"
 )
 
+func TestMain(m *testing.M) {
+	mappertest.Main(m)
+}
+
 func TestMapCgoPointer(t *testing.T) {
 	itest.RunTestMapCgoPointer(t)
 }
@@ -17,3 +26,179 @@ func TestMapCgoPointer(t *testing.T) {
 func TestMapGoKey(t *testing.T) {
 	itest.RunTestMapGoKey(t)
 }
+
+func TestTryGetNotMapped(t *testing.T) {
+	var m mapper.Mapper
+
+	key := m.MapValue("value")
+	m.Delete(key)
+
+	if _, ok := m.Lookup(key); ok {
+		t.Fatal("Lookup of deleted key succeeded")
+	}
+	_, err := m.TryGetHandle(key.Handle())
+	var nme *mapper.NotMappedError
+	if !errors.As(err, &nme) {
+		t.Fatalf("TryGetHandle returned %v, want *NotMappedError", err)
+	}
+	if nme.Key != key || nme.Kind != mapper.CountingKey {
+		t.Fatalf("NotMappedError has key 0x%x kind %v", nme.Key.Handle(), nme.Kind)
+	}
+}
+
+func TestTryGetUseAfterDelete(t *testing.T) {
+	var m mapper.Mapper
+
+	key := m.MapValue("value")
+	m.Delete(key)
+
+	// A deleted counting key is also reported as a *UseAfterDeleteError.
+	_, err := m.TryGet(key)
+	var uade *mapper.UseAfterDeleteError
+	if !errors.As(err, &uade) || uade.Key != key || uade.Cleared {
+		t.Fatalf("TryGet of deleted key returned %v, want *UseAfterDeleteError", err)
+	}
+
+	// A counting key that was never issued.
+	never := mapper.KeyFromHandle(key.Handle() + 2)
+	_, err = m.TryGet(never)
+	if errors.As(err, &uade) {
+		t.Fatalf("TryGet of key never issued returned %v", err)
+	}
+}
+
+func TestClearEpoch(t *testing.T) {
+	var m mapper.Mapper
+
+	old := m.MapValue("old")
+	m.Clear()
+	key := m.MapValue("new")
+	if key == old {
+		t.Fatalf("key 0x%x reissued after Clear", key.Handle())
+	}
+
+	_, err := m.TryGet(old)
+	var uade *mapper.UseAfterDeleteError
+	if !errors.As(err, &uade) || !uade.Cleared {
+		t.Fatalf("TryGet of key from before Clear returned %v", err)
+	}
+	if got := m.Get(key); got != "new" {
+		t.Fatalf("Get returned %v", got)
+	}
+}
+
+func TestClearConcurrent(t *testing.T) {
+	var m mapper.Mapper
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			m.MapValue(i)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			m.Clear()
+		}
+	}()
+	wg.Wait()
+}
+
+func TestTryDelete(t *testing.T) {
+	var m mapper.Mapper
+
+	key := m.MapValue("value")
+	if !m.TryDelete(key) {
+		t.Fatal("TryDelete of mapped key returned false")
+	}
+	if m.TryDeleteHandle(key.Handle()) {
+		t.Fatal("TryDeleteHandle of deleted key returned true")
+	}
+
+	// An unaligned pointer, e.g. from C, is not mapped, but doesn't panic.
+	buf := make([]byte, 16)
+	if m.TryDeletePtr(unsafe.Pointer(&buf[1])) {
+		t.Fatal("TryDeletePtr of unaligned pointer returned true")
+	}
+}
+
+func TestReadMostly(t *testing.T) {
+	m := mapper.New(mapper.WithReadMostly())
+
+	if _, ok := m.LookupHandle(1); ok {
+		t.Fatal("Lookup on empty mapper succeeded")
+	}
+	key := m.MapValue("value")
+	if got := m.Get(key); got != "value" {
+		t.Fatalf("Get returned %v", got)
+	}
+	m.Delete(key)
+	if _, ok := m.Lookup(key); ok {
+		t.Fatal("Lookup of deleted key succeeded")
+	}
+	key = m.MapValue("again")
+	m.Clear()
+	if _, ok := m.Lookup(key); ok {
+		t.Fatal("Lookup after Clear succeeded")
+	}
+}
+
+func TestReadMostlyConcurrent(t *testing.T) {
+	m := mapper.New(mapper.WithReadMostly())
+	stable := m.MapValue("stable")
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				if got := m.Get(stable); got != "stable" {
+					t.Errorf("Get returned %v", got)
+				}
+			}
+		}()
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				m.Delete(m.MapValue(g))
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+// Run with -race and -cpu to compare the lock-free read path against the
+// default RWMutex-protected one under parallelism.
+func BenchmarkReadMostlyGetParallel(b *testing.B) {
+	m := mapper.New(mapper.WithReadMostly())
+	benchGetParallel(b, m.MapValue, m.Get)
+}
+
+func BenchmarkReadMostlyGetHotKeyParallel(b *testing.B) {
+	benchHotKeyParallel(b, mapper.New(mapper.WithReadMostly()))
+}
+
+func BenchmarkReadMostlyTrafficStatsHotKeyParallel(b *testing.B) {
+	benchHotKeyParallel(b, mapper.New(mapper.WithReadMostly(), mapper.WithTrafficStats()))
+}
+
+// benchHotKeyParallel looks up a single key from every thread, as callbacks
+// into one long-lived object do.
+func benchHotKeyParallel(b *testing.B, m *mapper.Mapper) {
+	key := m.MapValue("hot")
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.Get(key)
+		}
+	})
+}
+
+func BenchmarkReadMostlyMixedParallel(b *testing.B) {
+	m := mapper.New(mapper.WithReadMostly())
+	benchMixedParallel(b, m.MapValue, m.Get, m.Delete)
+}
@@ -0,0 +1,57 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package mappertest provides helpers for testing code that uses package
+// mapper.
+package mappertest // go.jpap.org/mapper/mappertest
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"go.jpap.org/mapper"
+)
+
+// Main is meant to be called from TestMain.  It runs the tests, and then
+// fails the test binary if any of the given mappers (or mapper.G, if none are
+// given) still has live mappings, listing them on stderr.
+//
+//   func TestMain(m *testing.M) {
+//       mappertest.Main(m)
+//   }
+//
+// Build the tests with the "mapperdebug" tag, or create the mappers
+// WithDebug, to have the report include where each mapping was created.
+func Main(m *testing.M, mappers ...*mapper.Mapper) {
+	code := m.Run()
+	if code == 0 {
+		if err := Check(mappers...); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// Check returns an error listing the live mappings of the given mappers (or
+// mapper.G, if none are given), or nil if there are none.
+func Check(mappers ...*mapper.Mapper) error {
+	if len(mappers) == 0 {
+		mappers = []*mapper.Mapper{&mapper.G}
+	}
+	var b strings.Builder
+	n := 0
+	for _, m := range mappers {
+		for _, leak := range m.Leaks() {
+			fmt.Fprintf(&b, "\n%v", leak)
+			n++
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	return fmt.Errorf("mappertest: %d leaked mappings:%s", n, b.String())
+}
@@ -0,0 +1,304 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Option configures a Mapper created by New.
+type Option func(*Mapper)
+
+// New returns a new Mapper configured with the given options.  New() is
+// equivalent to the zero Mapper.
+func New(opts ...Option) *Mapper {
+	mapper := new(Mapper)
+	for _, opt := range opts {
+		opt(mapper)
+	}
+	mapper.format.init()
+	if mapper.table != nil {
+		if mapper.format.scrambler != nil {
+			panic("WithRandomKeys and WithHandleTable are exclusive")
+		}
+		mapper.table.format = &mapper.format
+	}
+	return mapper
+}
+
+// WithReadMostly selects a read-optimized Mapper, where Get, GetPtr,
+// GetHandle and their Lookup and TryGet counterparts take no lock.  Instead,
+// readers load an immutable snapshot of the mappings that is published
+// atomically.
+//
+// Writers pay the cost: each MapPair, MapValue or Delete copies all of the
+// mappings.  Use it for mappers that are rarely modified once populated, but
+// read from many threads.
+func WithReadMostly() Option {
+	return func(mapper *Mapper) {
+		mapper.readMostly = true
+	}
+}
+
+// WithHandleTable selects a handle-table backend for counting keys.  Each key
+// returned by MapValue encodes an index into a table of slots, together with
+// a generation counter: lookups index the table directly, and a slot is
+// reused with a new generation once its mapping is deleted, until its
+// generations run out.  A stale key whose mapping has been deleted is
+// reported by TryGet as a *UseAfterDeleteError, instead of resolving to a
+// newer value.
+//
+// The key space is still finite: each slot issues one key per generation.
+// On a 64-bit platform, that is 2^32 slots of 2^31 generations each, which a
+// program will not exhaust.  On a 32-bit platform, up to 65,536 mappings may
+// be live at once, but only 2^31 keys can be issued in all, as without a
+// handle table.  WithTag and WithHardenedHandles take their bits from the
+// generations, which lowers this further; see the KeySpace of Stats.
+//
+// Lookups of counting keys always take the read lock, even WithReadMostly.
+// Pointer keys are unaffected, and MapPair panics if given a counting key.
+func WithHandleTable() Option {
+	return func(mapper *Mapper) {
+		mapper.table = new(handleTable)
+	}
+}
+
+// WithTag encodes a tag that identifies the mapper in the highest bits of the
+// counting keys it issues, so that TryGet reports a key issued by another
+// mapper created WithTag as a *WrongMapperError, instead of resolving it to an
+// unrelated value.  The error names the mapper that issued the key, when it
+// was created by NewNamed with the same tag width.
+//
+// Tags are allocated in turn to each mapper created WithTag, and wrap around
+// after 2^bits-1 mappers, so more bits tell more mappers apart.  But each bit
+// halves the counting key space, or the number of generations of each slot
+// WithHandleTable: on a 32-bit platform, a handful of bits is a good trade.
+// WithTag panics unless 0 < bits < 15 on a 32-bit platform, or 0 < bits < 31
+// on a 64-bit one.  Pointer keys are unaffected.
+func WithTag(bits int) Option {
+	if bits < 1 || bits >= slotGenBits {
+		panic(fmt.Errorf("tag width out of range: %d bits", bits))
+	}
+	return func(mapper *Mapper) {
+		n := uintptr(atomic.AddUint32(&lastTag, 1))
+		mapper.format.tagBits = bits
+		mapper.format.tag = n%(1<<bits-1) + 1
+	}
+}
+
+// lastTag counts the mappers created WithTag.
+var lastTag uint32
+
+// WithHardenedHandles adds check bits to the counting keys the mapper issues:
+// a keyed hash of the rest of the key, with a secret chosen at random for
+// each process.  TryGet then reports a counting key whose check bits are
+// wrong, such as a garbage value that C code wrote over a handle, as an
+// *InvalidHandleError, rather than risk resolving it to another live mapping.
+//
+// The check is meant to catch corruption, not a determined attacker, and the
+// chance that a random value passes it is one in 2^bits.  Like those of
+// WithTag, each bit halves the counting key space, and the tag and check bits
+// together must fit in fewer than 15 bits on a 32-bit platform, or 31 bits on a
+// 64-bit one.  Pointer keys, which are even, are not checked.
+func WithHardenedHandles(bits int) Option {
+	if bits < 1 || bits >= slotGenBits {
+		panic(fmt.Errorf("check width out of range: %d bits", bits))
+	}
+	return func(mapper *Mapper) {
+		mapper.format.checkBits = bits
+	}
+}
+
+// WithRandomKeys makes MapValue issue counting keys drawn from a randomized,
+// sparse key space, rather than in sequence.  It is meant for tests, to catch
+// C code that mixes up two handles, or does arithmetic on them: with
+// sequential keys, such a mix-up is likely to land on another live mapping.
+//
+// The keys are a permutation of the sequential ones, keyed by seed, so they
+// remain unique, odd, and reproducible from run to run with the same seed.  A
+// zero seed is replaced by a random one, reported by KeySeed, which can be
+// logged so that a failing run can be reproduced.  WithRandomKeys cannot be
+// combined with WithHandleTable.
+func WithRandomKeys(seed uint64) Option {
+	return func(mapper *Mapper) {
+		if seed == 0 {
+			seed = randomSeed()
+		}
+		mapper.format.seed = seed
+	}
+}
+
+// WithOnDelete sets a deletion hook that is called with the key and Go value
+// of each mapping when it is deleted, typically to free an associated C
+// resource.
+//
+// Deletion hooks run exactly once per mapping, whether it is removed by
+// Delete, DeletePtr, DeleteHandle or their Try and AndWait variants, by Clear
+// or ClearAndWait, by a final Release, or replaced by MapPair.  They are
+// called in the goroutine that removed the mapping, after it has been removed
+// and without the mapper locked, so a hook may call back into the mapper: for
+// example, to delete related mappings.  A mapping's own OnDelete hook is
+// called before the mapper's.  A hook that panics propagates the panic to the
+// caller that removed the mapping.
+func WithOnDelete(hook func(key Key, goValue interface{})) Option {
+	return func(mapper *Mapper) {
+		mapper.onDelete = hook
+	}
+}
+
+// WithDebug enables debug mode, where each mapping records when and where it
+// was created, for Leaks to report.  Debug mode can also be enabled for all
+// mappers, including G, by building with the "mapperdebug" tag.
+func WithDebug() Option {
+	return func(mapper *Mapper) {
+		mapper.debug = true
+	}
+}
+
+// WithProfile adds the mapper's live mappings to the "mapper.live"
+// runtime/pprof profile, with the stack that created each one.  Use it to find
+// the code paths that leak mappings in a running program, e.g. with
+// "go tool pprof" and net/http/pprof.  Building with the "mapperdebug" tag
+// profiles all mappers, including G.
+func WithProfile() Option {
+	return func(mapper *Mapper) {
+		mapper.profile = true
+	}
+}
+
+// WithTrafficStats counts the lookups of mapped and unmapped keys, for the
+// Hits and Misses of Stats.  Counting is off by default, as it adds an atomic
+// increment to each lookup, which is a large share of the cost of Get in
+// read-mostly mode.  The counters are striped by goroutine, so that threads
+// looking up the same key don't contend on them.
+func WithTrafficStats() Option {
+	return func(mapper *Mapper) {
+		mapper.trafficStats = true
+	}
+}
+
+// WithQuarantine keeps tombstones for recently deleted keys, so that TryGet
+// reports a lookup of one as a *UseAfterDeleteError that says when it was
+// deleted and, in debug mode, from where.  Without a tombstone, a deleted
+// pointer key cannot be told apart from one that was never mapped, or was
+// forged by memory corruption.
+//
+// At most max tombstones are kept, each for at most ttl; a zero max or ttl
+// means no limit on that count or age, but the quarantine must be bounded by
+// at least one of them: WithQuarantine panics if both are zero, or either is
+// negative.  A key that is mapped again leaves quarantine.
+func WithQuarantine(max int, ttl time.Duration) Option {
+	if max < 0 || ttl < 0 || (max == 0 && ttl == 0) {
+		panic(fmt.Errorf("quarantine is unbounded: max %d, ttl %v", max, ttl))
+	}
+	return func(mapper *Mapper) {
+		mapper.quarantine = &quarantine{max: max, ttl: ttl}
+	}
+}
+
+// WithFlightRecorder records the last n mapper operations: new mappings,
+// lookups of keys that are not mapped, deletions and clears, each with the
+// key, goroutine and time.  They can be dumped on demand with Recent or
+// WriteRecent, and are attached to the errors returned for keys that are not
+// mapped, so that they also appear in the panic raised by Get.
+func WithFlightRecorder(n int) Option {
+	return func(mapper *Mapper) {
+		if n > 0 {
+			mapper.recorder = &recorder{events: make([]Event, n)}
+		}
+	}
+}
+
+// WithClock sets the clock used to timestamp mappings, tombstones and
+// events, and to expire mappings created with a TTL or idle timeout.  It is
+// mainly for tests, which can advance a fake clock and then call Expire.
+func WithClock(now func() time.Time) Option {
+	return func(mapper *Mapper) {
+		mapper.clock = now
+	}
+}
+
+// WithOnEvict sets an eviction hook that is called with the key, Go value and
+// reason of each mapping that is evicted by its TTL, the idle timeout, or the
+// capacity of the mapper.  Like a deletion hook, it is called without the
+// mapper locked; the deletion hooks are called after it.
+func WithOnEvict(hook func(key Key, goValue interface{}, reason EvictReason)) Option {
+	return func(mapper *Mapper) {
+		mapper.onEvict = hook
+	}
+}
+
+// WithIdleTimeout evicts mappings that have not been looked up by Get,
+// TryGet, Lookup or Borrow (or their Ptr and Handle variants) for longer than
+// d.  It is for mappings whose C library never says when it is done with
+// them.  Each lookup then also takes an internal lock to record the use.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(mapper *Mapper) {
+		mapper.idleTimeout = d
+		if mapper.lru == nil {
+			mapper.lru = new(lru)
+		}
+	}
+}
+
+// WithCapacity bounds the mapper to n live mappings: a new mapping beyond
+// that evicts the least recently used one.  As for WithIdleTimeout, each
+// lookup then also takes an internal lock to record the use.
+func WithCapacity(n int) Option {
+	return func(mapper *Mapper) {
+		mapper.capacity = n
+		if mapper.lru == nil {
+			mapper.lru = new(lru)
+		}
+	}
+}
+
+// MapOption configures a single mapping created by MapPair, MapPtrPair or
+// MapValue.
+type MapOption func(*entry)
+
+// RefCounted creates a reference-counted mapping, with an initial count of
+// one.  The count is incremented by Retain, and decremented by Release: the
+// mapping is deleted once it reaches zero.
+func RefCounted() MapOption {
+	return func(e *entry) {
+		e.extra().refs = 1
+	}
+}
+
+// OnDelete sets a deletion hook that is called with the key and Go value when
+// the mapping is deleted; see WithOnDelete.
+func OnDelete(hook func(key Key, goValue interface{})) MapOption {
+	return func(e *entry) {
+		e.extra().onDelete = hook
+	}
+}
+
+// OnRelease sets a hook that is called with the key and Go value after a
+// RefCounted mapping is deleted by Release.  It is not called when the
+// mapping is deleted by Delete or Clear.
+func OnRelease(hook func(key Key, goValue interface{})) MapOption {
+	return func(e *entry) {
+		e.extra().onRelease = hook
+	}
+}
+
+// TTL evicts the mapping once d has passed since it was created.
+func TTL(d time.Duration) MapOption {
+	return func(e *entry) {
+		e.extra().ttl = d
+	}
+}
+
+// OnEvict sets an eviction hook that is called with the key, Go value and
+// reason when the mapping is evicted; see WithOnEvict.  A mapping's own hook
+// is called before the mapper's.
+func OnEvict(hook func(key Key, goValue interface{}, reason EvictReason)) MapOption {
+	return func(e *entry) {
+		e.extra().onEvict = hook
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+import (
+	"runtime/pprof"
+	"sync"
+)
+
+// profileName is the name of the runtime/pprof profile of live mappings.
+const profileName = "mapper.live"
+
+// liveProfile is created on first use, so that the profile is only listed
+// when a mapper is profiled.
+var liveProfile = sync.OnceValue(func() *pprof.Profile {
+	return pprof.NewProfile(profileName)
+})
+
+// profiling reports whether the mapper's live mappings are profiled.
+func (mapper *Mapper) profiling() bool {
+	return debugDefault || mapper.profile
+}
+
+// profileAdd adds a new mapping to the profile.
+func (mapper *Mapper) profileAdd(e *entry) {
+	if mapper.profiling() {
+		// Skip profileAdd itself; the mapper frames that remain show which
+		// method created the mapping.
+		liveProfile().Add(e, 1)
+	}
+}
+
+// profileRemove removes a deleted mapping from the profile.
+func (mapper *Mapper) profileRemove(e *entry) {
+	if mapper.profiling() {
+		liveProfile().Remove(e)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"runtime/pprof"
+	"strings"
+	"testing"
+
+	"go.jpap.org/mapper"
+)
+
+func TestProfile(t *testing.T) {
+	m := mapper.New(mapper.WithProfile(), mapper.WithHandleTable())
+
+	count := func() int {
+		p := pprof.Lookup("mapper.live")
+		if p == nil {
+			t.Fatal("mapper.live profile not registered")
+		}
+		return p.Count()
+	}
+
+	key := m.MapValue("value")
+	before := count()
+	other := m.MapValue("other")
+	if n := count(); n != before+1 {
+		t.Fatalf("profile count is %d after MapValue, want %d", n, before+1)
+	}
+
+	var b strings.Builder
+	if err := pprof.Lookup("mapper.live").WriteTo(&b, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "TestProfile") {
+		t.Errorf("profile does not include the creation stack:\n%s", b.String())
+	}
+
+	m.Delete(other)
+	if n := count(); n != before {
+		t.Fatalf("profile count is %d after Delete, want %d", n, before)
+	}
+	m.Clear()
+	if n := count(); n != before-1 {
+		t.Fatalf("profile count is %d after Clear, want %d", n, before-1)
+	}
+	if _, ok := m.Lookup(key); ok {
+		t.Fatal("Lookup after Clear succeeded")
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+import (
+	"time"
+)
+
+// quarantine keeps tombstones for recently deleted keys, so that a later
+// lookup can be reported as a use-after-delete.  It is protected by
+// Mapper.mux.
+type quarantine struct {
+	max int           // maximum number of tombstones, or zero for no limit
+	ttl time.Duration // maximum age of a tombstone, or zero for no limit
+
+	graves map[Key]*tombstone
+	queue  []*tombstone // oldest first; may include exhumed tombstones
+}
+
+// minCompact is the number of exhumed tombstones that the queue may hold
+// beyond the live ones before it is compacted.
+const minCompact = 64
+
+type tombstone struct {
+	key     Key
+	deleted time.Time
+	stack   []uintptr // in debug mode
+	cleared bool
+	cause   error // why the mapping was canceled, see MapValueContext
+}
+
+// bury records a tombstone for key, and prunes the oldest ones.
+func (q *quarantine) bury(t *tombstone) {
+	if q.graves == nil {
+		q.graves = make(map[Key]*tombstone)
+	}
+	q.graves[t.key] = t
+	q.queue = append(q.queue, t)
+	for len(q.queue) > 0 {
+		oldest := q.queue[0]
+		if q.graves[oldest.key] == oldest && !q.expired(oldest, t.deleted) && (q.max == 0 || len(q.graves) <= q.max) {
+			break
+		}
+		if q.graves[oldest.key] == oldest {
+			delete(q.graves, oldest.key)
+		}
+		q.queue[0] = nil
+		q.queue = q.queue[1:]
+	}
+	if len(q.queue) > 2*len(q.graves)+minCompact {
+		q.compact()
+	}
+}
+
+// compact drops the exhumed tombstones from the queue, which pruning only
+// skips once they reach its front.  Without it, a key that is mapped and
+// deleted again and again, behind a live tombstone, grows the queue without
+// bound.
+func (q *quarantine) compact() {
+	live := q.queue[:0]
+	for _, t := range q.queue {
+		if q.graves[t.key] == t {
+			live = append(live, t)
+		}
+	}
+	for i := len(live); i < len(q.queue); i++ {
+		q.queue[i] = nil
+	}
+	q.queue = live
+}
+
+// exhume removes the tombstone for key, which is being mapped again.
+func (q *quarantine) exhume(key Key) {
+	delete(q.graves, key)
+}
+
+// find returns the tombstone for key, or nil if there is none.
+func (q *quarantine) find(key Key, now time.Time) *tombstone {
+	t := q.graves[key]
+	if t == nil || q.expired(t, now) {
+		return nil
+	}
+	return t
+}
+
+func (q *quarantine) expired(t *tombstone, now time.Time) bool {
+	return q.ttl != 0 && now.Sub(t.deleted) > q.ttl
+}
+
+// buryLocked records a tombstone for a deleted key, if the mapper has a
+// quarantine.
+func (mapper *Mapper) buryLocked(key Key, cleared bool) {
+	if mapper.quarantine == nil {
+		return
+	}
+	t := &tombstone{key: key, deleted: mapper.now(), cleared: cleared}
+	if mapper.debugging() {
+		t.stack = callers()
+	}
+	mapper.quarantine.bury(t)
+}
+
+// useAfterDeleteLocked returns a *UseAfterDeleteError for key if it has a
+// tombstone, and otherwise err, the reason the key was not found.
+func (mapper *Mapper) useAfterDeleteLocked(key Key, err error) error {
+	var t *tombstone
+	now := mapper.now()
+	if q := mapper.canceled.Load(); q != nil {
+		t = q.find(key, now)
+	}
+	if t == nil && mapper.quarantine != nil {
+		t = mapper.quarantine.find(key, now)
+	}
+	if t == nil {
+		return err
+	}
+	return &UseAfterDeleteError{Key: key, Cleared: t.cleared, Cause: t.cause, Deleted: t.deleted, Stack: t.stack}
+}
@@ -0,0 +1,104 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"go.jpap.org/mapper"
+)
+
+func TestQuarantine(t *testing.T) {
+	m := mapper.New(mapper.WithQuarantine(2, 0), mapper.WithDebug())
+
+	buf := make([]uint64, 3)
+	keys := make([]mapper.Key, len(buf))
+	for i := range buf {
+		keys[i] = m.MapPtrPair(unsafe.Pointer(&buf[i]), i)
+	}
+	before := time.Now()
+	for _, key := range keys {
+		m.Delete(key)
+	}
+
+	// The oldest tombstone was pruned.
+	_, err := m.TryGet(keys[0])
+	var nme *mapper.NotMappedError
+	var uade *mapper.UseAfterDeleteError
+	if !errors.As(err, &nme) || errors.As(err, &uade) {
+		t.Fatalf("TryGet of pruned key returned %v, want *NotMappedError", err)
+	}
+
+	_, err = m.TryGet(keys[2])
+	if !errors.As(err, &uade) {
+		t.Fatalf("TryGet of quarantined key returned %v, want *UseAfterDeleteError", err)
+	}
+	if uade.Cleared || uade.Deleted.Before(before) {
+		t.Errorf("UseAfterDeleteError has Cleared %v, Deleted %v", uade.Cleared, uade.Deleted)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "TestQuarantine") {
+		t.Errorf("error does not name the deletion site:\n%s", msg)
+	}
+
+	// Mapping a key again takes it out of quarantine.
+	m.MapPair(keys[2], "again")
+	if got := m.Get(keys[2]); got != "again" {
+		t.Fatalf("Get returned %v", got)
+	}
+	m.Clear()
+	_, err = m.TryGet(keys[2])
+	if !errors.As(err, &uade) || !uade.Cleared {
+		t.Fatalf("TryGet of cleared key returned %v", err)
+	}
+}
+
+func TestQuarantineTTL(t *testing.T) {
+	m := mapper.New(mapper.WithQuarantine(0, time.Nanosecond), mapper.WithReadMostly())
+
+	buf := make([]uint64, 1)
+	key := m.MapPtrPair(unsafe.Pointer(&buf[0]), "value")
+	m.Delete(key)
+	time.Sleep(time.Millisecond)
+
+	_, err := m.TryGet(key)
+	var nme *mapper.NotMappedError
+	var uade *mapper.UseAfterDeleteError
+	if !errors.As(err, &nme) || errors.As(err, &uade) {
+		t.Fatalf("TryGet of expired tombstone returned %v, want *NotMappedError", err)
+	}
+}
+
+func TestQuarantineUnbounded(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("WithQuarantine(0, 0) did not panic")
+		}
+	}()
+	mapper.WithQuarantine(0, 0)
+}
+
+func TestQuarantineChurn(t *testing.T) {
+	m := mapper.New(mapper.WithQuarantine(10, 0))
+
+	buf := make([]uint64, 2)
+	old := m.MapPtrPair(unsafe.Pointer(&buf[0]), "old")
+	m.Delete(old)
+	ptr := unsafe.Pointer(&buf[1])
+	for i := 0; i < 100000; i++ {
+		m.MapPtrPair(ptr, i)
+		m.DeletePtr(ptr)
+	}
+
+	var uade *mapper.UseAfterDeleteError
+	for _, key := range []mapper.Key{old, mapper.KeyFromPtr(ptr)} {
+		if _, err := m.TryGet(key); !errors.As(err, &uade) {
+			t.Fatalf("TryGet(0x%x) returned %v, want *UseAfterDeleteError", key.Handle(), err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+import (
+	"math/rand/v2"
+)
+
+// scrambler is a bijection over the n-bit counter field of counting keys,
+// that spreads a Mapper's sequential counter over the whole field for
+// WithRandomKeys.  Being invertible, it keeps keys unique, and lets a key be
+// traced back to its counter to tell a deleted key from one never issued.
+//
+// Each of its rounds adds a constant, multiplies by an odd constant, and then
+// xors the high half of the bits into the low half, all modulo 2^n.
+type scrambler struct {
+	mask  uintptr
+	shift uint
+	add   [2]uintptr
+	mul   [2]uintptr // odd
+	inv   [2]uintptr // inverses of mul modulo 2^n
+}
+
+func newScrambler(n int, seed uint64) *scrambler {
+	s := &scrambler{
+		mask:  1<<n - 1,
+		shift: uint(n+1) / 2,
+	}
+	for i := range s.add {
+		seed, s.add[i] = splitMix64(seed)
+		seed, s.mul[i] = splitMix64(seed)
+		s.add[i] &= s.mask
+		s.mul[i] |= 1
+		s.inv[i] = inverse(s.mul[i])
+	}
+	return s
+}
+
+func (s *scrambler) forward(x uintptr) uintptr {
+	for i := range s.add {
+		x = (x + s.add[i]) * s.mul[i] & s.mask
+		x ^= x >> s.shift
+	}
+	return x
+}
+
+func (s *scrambler) backward(x uintptr) uintptr {
+	for i := len(s.add) - 1; i >= 0; i-- {
+		// The xorshift is its own inverse, as 2*shift >= n.
+		x ^= x >> s.shift
+		x = (x*s.inv[i] - s.add[i]) & s.mask
+	}
+	return x
+}
+
+// inverse returns the multiplicative inverse of an odd number modulo 2^ptrBits,
+// and so also modulo any smaller power of two, by Newton's method.
+func inverse(a uintptr) uintptr {
+	x := a // correct to 3 bits
+	for i := 0; i < 5; i++ {
+		x *= 2 - a*x
+	}
+	return x
+}
+
+// splitMix64 returns the next state and output of a SplitMix64 generator.
+func splitMix64(state uint64) (uint64, uintptr) {
+	state += 0x9e3779b97f4a7c15
+	z := state
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return state, uintptr(z ^ z>>31)
+}
+
+// randomSeed returns a seed for WithRandomKeys(0).
+func randomSeed() uint64 {
+	for {
+		if seed := rand.Uint64(); seed != 0 {
+			return seed
+		}
+	}
+}
+
+// KeySeed returns the seed of a mapper created WithRandomKeys, or zero.
+func (mapper *Mapper) KeySeed() uint64 {
+	return mapper.format.seed
+}
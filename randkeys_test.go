@@ -0,0 +1,68 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"errors"
+	"testing"
+
+	"go.jpap.org/mapper"
+)
+
+func TestRandomKeys(t *testing.T) {
+	for _, opts := range [][]mapper.Option{
+		{mapper.WithRandomKeys(42)},
+		{mapper.WithRandomKeys(42), mapper.WithTag(4), mapper.WithHardenedHandles(8)},
+	} {
+		m := mapper.New(opts...)
+		seen := make(map[mapper.Key]bool)
+		var keys []mapper.Key
+		for i := 0; i < 1000; i++ {
+			key := m.MapValue(i)
+			if key.Kind() != mapper.CountingKey || seen[key] {
+				t.Fatalf("key 0x%x is not a new counting key", key.Handle())
+			}
+			seen[key] = true
+			keys = append(keys, key)
+		}
+		if keys[1].Handle()-keys[0].Handle() == 2 && keys[2].Handle()-keys[1].Handle() == 2 {
+			t.Fatal("keys are sequential")
+		}
+		for i, key := range keys {
+			if got := m.Get(key); got != i {
+				t.Fatalf("Get returned %v, want %d", got, i)
+			}
+		}
+
+		// Deleted keys are still told apart from keys never issued.
+		m.Delete(keys[0])
+		var uade *mapper.UseAfterDeleteError
+		if _, err := m.TryGet(keys[0]); !errors.As(err, &uade) {
+			t.Fatalf("TryGet of deleted key returned %v", err)
+		}
+		m.Clear()
+	}
+}
+
+func TestRandomKeysSeed(t *testing.T) {
+	a := mapper.New(mapper.WithRandomKeys(7))
+	b := mapper.New(mapper.WithRandomKeys(7))
+	c := mapper.New(mapper.WithRandomKeys(8))
+	ka, kb, kc := a.MapValue("a"), b.MapValue("b"), c.MapValue("c")
+	if ka != kb {
+		t.Fatalf("same seed gave keys 0x%x and 0x%x", ka.Handle(), kb.Handle())
+	}
+	if ka == kc {
+		t.Fatalf("different seeds gave key 0x%x", ka.Handle())
+	}
+
+	r := mapper.New(mapper.WithRandomKeys(0))
+	if r.KeySeed() == 0 {
+		t.Fatal("KeySeed is zero for a random seed")
+	}
+	a.Delete(ka)
+	b.Delete(kb)
+	c.Delete(kc)
+}
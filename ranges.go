@@ -0,0 +1,146 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+import (
+	"fmt"
+	"sort"
+	"unsafe"
+)
+
+// MapRange maps the memory range [ptr, ptr+size) to a Go value, so that
+// GetContaining resolves any address within it, such as a pointer to a field
+// inside a struct, or into a buffer, that C passes back instead of ptr.
+//
+// The range is mapped via a counting key that is allocated for it as by
+// MapValue, and returned, so it can't collide with other keys; it can be used
+// to Get or Delete the mapping.  MapRange returns an error, and maps nothing,
+// if the range is empty, wraps around the address space, or overlaps a range
+// that is already mapped.
+func (mapper *Mapper) MapRange(ptr unsafe.Pointer, size uintptr, goValue interface{}, opts ...MapOption) (Key, error) {
+	start := uintptr(ptr)
+	end := start + size
+	if size == 0 || end < start {
+		return Key{}, fmt.Errorf("invalid range: 0x%x bytes at 0x%x", size, start)
+	}
+	e := mapper.newEntry(goValue, opts)
+	x := e.extra()
+	x.start, x.end = start, end
+	key, full, err := mapper.mapRange(e)
+	if err != nil {
+		return Key{}, err
+	}
+	mapper.evictedAll(full)
+	return key, nil
+}
+
+// mapRange maps e to a new counting key, and adds it to ranges.  It returns
+// the key and the entries evicted to make room for it, or an error if e
+// overlaps a mapped range.
+func (mapper *Mapper) mapRange(e *entry) (key Key, full []*entry, err error) {
+	mapper.lock()
+	defer mapper.mux.Unlock()
+	// The first range that ends after e starts is the only one that may overlap.
+	i := sort.Search(len(mapper.ranges), func(i int) bool {
+		return mapper.ranges[i].x.end > e.x.start
+	})
+	if i < len(mapper.ranges) && mapper.ranges[i].x.start < e.x.end {
+		r := mapper.ranges[i]
+		return Key{}, nil, fmt.Errorf("range [0x%x, 0x%x) overlaps mapped range [0x%x, 0x%x)", e.x.start, e.x.end, r.x.start, r.x.end)
+	}
+	key, full = mapper.allocValueLocked(e)
+	// Evictions may have removed ranges, so search again.
+	i = sort.Search(len(mapper.ranges), func(i int) bool {
+		return mapper.ranges[i].x.start >= e.x.end
+	})
+	mapper.ranges = append(mapper.ranges, nil)
+	copy(mapper.ranges[i+1:], mapper.ranges[i:])
+	mapper.ranges[i] = e
+	return key, full, nil
+}
+
+// unindexRangeLocked removes a removed mapping from ranges.
+func (mapper *Mapper) unindexRangeLocked(e *entry) {
+	i := sort.Search(len(mapper.ranges), func(i int) bool {
+		return mapper.ranges[i].x.start >= e.x.start
+	})
+	if i < len(mapper.ranges) && mapper.ranges[i] == e {
+		copy(mapper.ranges[i:], mapper.ranges[i+1:])
+		mapper.ranges[len(mapper.ranges)-1] = nil
+		mapper.ranges = mapper.ranges[:len(mapper.ranges)-1]
+	}
+}
+
+// rangeLocked returns the mapping whose range contains p, or nil.
+func (mapper *Mapper) rangeLocked(p uintptr) *entry {
+	i := sort.Search(len(mapper.ranges), func(i int) bool {
+		return mapper.ranges[i].x.end > p
+	})
+	if i < len(mapper.ranges) && mapper.ranges[i].x.start <= p {
+		return mapper.ranges[i]
+	}
+	return nil
+}
+
+// GetContaining retrieves the Go value of the range mapped by MapRange that
+// contains ptr.  It panics with the error from TryGetContaining if there is
+// none.
+func (mapper *Mapper) GetContaining(ptr unsafe.Pointer) (goValue interface{}) {
+	goValue, err := mapper.TryGetContaining(ptr)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// TryGetContaining is like GetContaining, but returns a *NotMappedError for
+// ptr instead of panicking, if no mapped range contains it.
+func (mapper *Mapper) TryGetContaining(ptr unsafe.Pointer) (goValue interface{}, err error) {
+	p := uintptr(ptr)
+	key := Key{p}
+	var e *entry
+	mapper.mux.RLock()
+	if r := mapper.rangeLocked(p); r != nil {
+		// Counts the hit, as for any lookup.
+		key = r.key
+		e, err = mapper.findLocked(key)
+	} else {
+		err = &NotMappedError{Key: key, Kind: PointerKey}
+	}
+	mapper.mux.RUnlock()
+	if err != nil {
+		return nil, mapper.miss(key, err)
+	}
+	return e.value, nil
+}
+
+// LookupContaining is like GetContaining, but reports whether a mapped range
+// contains ptr, instead of panicking.
+func (mapper *Mapper) LookupContaining(ptr unsafe.Pointer) (goValue interface{}, ok bool) {
+	goValue, err := mapper.TryGetContaining(ptr)
+	return goValue, err == nil
+}
+
+// DeleteRange deletes the range mapped by MapRange that contains ptr, if
+// any.
+func (mapper *Mapper) DeleteRange(ptr unsafe.Pointer) {
+	mapper.TryDeleteRange(ptr)
+}
+
+// TryDeleteRange is like DeleteRange, and reports whether a range was
+// deleted.
+func (mapper *Mapper) TryDeleteRange(ptr unsafe.Pointer) (deleted bool) {
+	mapper.lock()
+	var e *entry
+	if r := mapper.rangeLocked(uintptr(ptr)); r != nil {
+		e = mapper.removeLocked(r.key)
+	}
+	mapper.mux.Unlock()
+	if e == nil {
+		return false
+	}
+	mapper.deleted(e)
+	return true
+}
@@ -0,0 +1,67 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"errors"
+	"testing"
+	"unsafe"
+
+	"go.jpap.org/mapper"
+)
+
+// rangeBuf stands in for C memory, which unlike the stack never moves.
+var rangeBuf [64]byte
+
+func TestMapRange(t *testing.T) {
+	var m mapper.Mapper
+	at := func(i int) unsafe.Pointer { return unsafe.Pointer(&rangeBuf[i]) }
+
+	low, err := m.MapRange(at(0), 16, "low")
+	if err != nil {
+		t.Fatalf("MapRange: %v", err)
+	}
+	if _, err := m.MapRange(at(32), 16, "high"); err != nil {
+		t.Fatalf("MapRange: %v", err)
+	}
+	if _, err := m.MapRange(at(16), 16, "middle"); err != nil {
+		t.Fatalf("MapRange of adjacent range: %v", err)
+	}
+
+	for i, want := range map[int]interface{}{0: "low", 15: "low", 16: "middle", 31: "middle", 32: "high", 47: "high"} {
+		if got := m.GetContaining(at(i)); got != want {
+			t.Errorf("GetContaining(&rangeBuf[%d]) returned %v, want %v", i, got, want)
+		}
+	}
+	if got := m.Get(low); got != "low" {
+		t.Fatalf("Get of range key returned %v", got)
+	}
+	_, err = m.TryGetContaining(at(48))
+	var nme *mapper.NotMappedError
+	if !errors.As(err, &nme) || nme.Key.Handle() != uintptr(at(48)) {
+		t.Fatalf("TryGetContaining past the ranges returned %v", err)
+	}
+
+	for _, r := range []struct{ i, size int }{{8, 16}, {40, 16}, {0, 64}, {20, 1}, {60, 0}} {
+		if _, err := m.MapRange(at(r.i), uintptr(r.size), "bad"); err == nil {
+			t.Errorf("MapRange of %d bytes at &rangeBuf[%d] succeeded", r.size, r.i)
+		}
+	}
+
+	if !m.TryDeleteRange(at(20)) || m.TryDeleteRange(at(20)) {
+		t.Fatal("TryDeleteRange did not delete the range once")
+	}
+	if _, ok := m.LookupContaining(at(20)); ok {
+		t.Fatal("LookupContaining of deleted range succeeded")
+	}
+	m.Delete(low)
+	if _, ok := m.LookupContaining(at(0)); ok {
+		t.Fatal("LookupContaining of range deleted by key succeeded")
+	}
+	m.Clear()
+	if _, ok := m.LookupContaining(at(32)); ok {
+		t.Fatal("LookupContaining succeeded after Clear")
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+import "unsafe"
+
+// MapRawPtrPair is like MapPtrPair, but accepts any pointer value, including
+// the odd (byte-aligned) pointers into packed buffers or string tables that
+// KeyFromPtr rejects, as its low bit marks counting keys.
+//
+// An aligned pointer is mapped by MapPtrPair, and its key returned.  An odd
+// pointer is mapped in a separate keyspace, via a counting key that is
+// allocated for it as by MapValue, so that it can't collide with the keys that
+// MapValue returns.  That key is returned, and can be used to Get or Delete
+// the mapping, but the pointer must be looked up by GetRawPtr and its
+// variants, not GetPtr.  Mapping the same odd pointer again replaces its
+// mapping, with a new key.
+func (mapper *Mapper) MapRawPtrPair(ptr unsafe.Pointer, goValue interface{}, opts ...MapOption) Key {
+	p := uintptr(ptr)
+	if p&countingPointerBit == 0 {
+		return mapper.MapPtrPair(ptr, goValue, opts...)
+	}
+	e := mapper.newEntry(goValue, opts)
+	e.extra().rawPtr = p
+	key, old, full := mapper.mapRaw(e)
+	if old != nil {
+		mapper.deleted(old)
+	}
+	mapper.evictedAll(full)
+	return key
+}
+
+// mapRaw maps e to a new counting key, and indexes it by its odd pointer.  It
+// returns the key, the entry it replaced for the pointer, if any, and the
+// entries evicted to make room for it.
+func (mapper *Mapper) mapRaw(e *entry) (key Key, old *entry, full []*entry) {
+	mapper.lock()
+	defer mapper.mux.Unlock()
+	if oldKey, ok := mapper.raw[e.x.rawPtr]; ok {
+		old = mapper.removeLocked(oldKey)
+	}
+	key, full = mapper.allocValueLocked(e)
+	if mapper.raw == nil {
+		mapper.raw = make(map[uintptr]Key)
+	}
+	mapper.raw[e.x.rawPtr] = key
+	return key, old, full
+}
+
+// unindexLocked removes a removed mapping from raw or ranges.
+func (mapper *Mapper) unindexLocked(e *entry) {
+	x := e.x
+	if x == nil {
+		return
+	}
+	if x.rawPtr != 0 && mapper.raw[x.rawPtr] == e.key {
+		delete(mapper.raw, x.rawPtr)
+	}
+	if x.end != 0 {
+		mapper.unindexRangeLocked(e)
+	}
+}
+
+// rawKey returns the key that ptr is mapped by, as by MapRawPtrPair.  An odd
+// pointer that is not mapped is counted and recorded as a miss, and returns a
+// *NotMappedError.
+func (mapper *Mapper) rawKey(ptr unsafe.Pointer) (Key, error) {
+	p := uintptr(ptr)
+	if p&countingPointerBit == 0 {
+		return Key{p}, nil
+	}
+	mapper.mux.RLock()
+	key, ok := mapper.raw[p]
+	mapper.mux.RUnlock()
+	if ok {
+		return key, nil
+	}
+	return Key{}, mapper.miss(Key{p}, &NotMappedError{Key: Key{p}, Kind: PointerKey})
+}
+
+// GetRawPtr is like GetPtr, but for a pointer mapped by MapRawPtrPair, which
+// may be odd.
+func (mapper *Mapper) GetRawPtr(ptr unsafe.Pointer) (goValue interface{}) {
+	goValue, err := mapper.TryGetRawPtr(ptr)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// TryGetRawPtr is like TryGetPtr, but for a pointer mapped by MapRawPtrPair,
+// which may be odd.
+func (mapper *Mapper) TryGetRawPtr(ptr unsafe.Pointer) (goValue interface{}, err error) {
+	key, err := mapper.rawKey(ptr)
+	if err != nil {
+		return nil, err
+	}
+	return mapper.TryGet(key)
+}
+
+// LookupRawPtr is like LookupPtr, but for a pointer mapped by MapRawPtrPair,
+// which may be odd.
+func (mapper *Mapper) LookupRawPtr(ptr unsafe.Pointer) (goValue interface{}, ok bool) {
+	goValue, err := mapper.TryGetRawPtr(ptr)
+	return goValue, err == nil
+}
+
+// DeleteRawPtr is like DeletePtr, but for a pointer mapped by MapRawPtrPair,
+// which may be odd.
+func (mapper *Mapper) DeleteRawPtr(ptr unsafe.Pointer) {
+	mapper.TryDeleteRawPtr(ptr)
+}
+
+// TryDeleteRawPtr is like TryDeletePtr, but for a pointer mapped by
+// MapRawPtrPair, which may be odd.
+func (mapper *Mapper) TryDeleteRawPtr(ptr unsafe.Pointer) (deleted bool) {
+	p := uintptr(ptr)
+	if p&countingPointerBit == 0 {
+		return mapper.TryDeletePtr(ptr)
+	}
+	mapper.lock()
+	var e *entry
+	if key, ok := mapper.raw[p]; ok {
+		e = mapper.removeLocked(key)
+	}
+	mapper.mux.Unlock()
+	if e == nil {
+		return false
+	}
+	mapper.deleted(e)
+	return true
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"errors"
+	"testing"
+	"unsafe"
+
+	"go.jpap.org/mapper"
+)
+
+func TestRawPtr(t *testing.T) {
+	for _, opts := range [][]mapper.Option{
+		nil,
+		{mapper.WithHandleTable()},
+		{mapper.WithReadMostly()},
+	} {
+		deleted := make(map[interface{}]int)
+		m := mapper.New(append(opts, mapper.WithOnDelete(func(k mapper.Key, v interface{}) {
+			deleted[v]++
+		}))...)
+
+		buf := make([]byte, 8)
+		aligned := unsafe.Pointer(&buf[0])
+		odd := unsafe.Pointer(&buf[1])
+
+		m.MapRawPtrPair(aligned, "aligned")
+		oddKey := m.MapRawPtrPair(odd, "odd")
+		value := m.MapValue("value")
+
+		if got := m.GetPtr(aligned); got != "aligned" {
+			t.Fatalf("GetPtr returned %v", got)
+		}
+		if got := m.GetRawPtr(aligned); got != "aligned" {
+			t.Fatalf("GetRawPtr of aligned pointer returned %v", got)
+		}
+		if got := m.GetRawPtr(odd); got != "odd" {
+			t.Fatalf("GetRawPtr returned %v", got)
+		}
+		if got := m.Get(oddKey); got != "odd" {
+			t.Fatalf("Get of odd pointer key returned %v", got)
+		}
+		if got := m.Get(value); got != "value" {
+			t.Fatalf("Get returned %v", got)
+		}
+
+		// Mapping the pointer again replaces it.
+		m.MapRawPtrPair(odd, "again")
+		if got := m.GetRawPtr(odd); got != "again" || deleted["odd"] != 1 {
+			t.Fatalf("GetRawPtr returned %v after remapping", got)
+		}
+
+		if !m.TryDeleteRawPtr(odd) || m.TryDeleteRawPtr(odd) {
+			t.Fatal("TryDeleteRawPtr did not delete the mapping once")
+		}
+		_, err := m.TryGetRawPtr(odd)
+		var nme *mapper.NotMappedError
+		if !errors.As(err, &nme) || nme.Key.Handle() != uintptr(odd) {
+			t.Fatalf("TryGetRawPtr of deleted pointer returned %v", err)
+		}
+		if _, ok := m.LookupRawPtr(odd); ok {
+			t.Fatal("LookupRawPtr of deleted pointer succeeded")
+		}
+
+		m.MapRawPtrPair(odd, "clear")
+		m.Clear()
+		if _, ok := m.LookupRawPtr(odd); ok {
+			t.Fatal("LookupRawPtr succeeded after Clear")
+		}
+		if deleted["again"] != 1 || deleted["clear"] != 1 {
+			t.Fatalf("hooks called %v", deleted)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Op is a mapper operation recorded by the flight recorder.
+type Op int
+
+const (
+	// OpMap records a new mapping, by MapPair, MapPtrPair or MapValue.
+	OpMap Op = iota
+	// OpMiss records a lookup of a key that was not mapped.
+	OpMiss
+	// OpDelete records the deletion of a mapping.
+	OpDelete
+	// OpClear records a Clear.
+	OpClear
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpMap:
+		return "map"
+	case OpMiss:
+		return "miss"
+	case OpDelete:
+		return "delete"
+	case OpClear:
+		return "clear"
+	}
+	return fmt.Sprintf("Op(%d)", int(op))
+}
+
+// Event is an operation recorded by the flight recorder.
+type Event struct {
+	Op        Op
+	Key       Key // zero for OpClear
+	Goroutine uint64
+	Time      time.Time
+}
+
+func (ev Event) String() string {
+	if ev.Op == OpClear {
+		return fmt.Sprintf("%v goroutine %d: %v", ev.Time.Format(time.RFC3339Nano), ev.Goroutine, ev.Op)
+	}
+	return fmt.Sprintf("%v goroutine %d: %v 0x%x (%v)", ev.Time.Format(time.RFC3339Nano), ev.Goroutine, ev.Op, ev.Key.v, ev.Key.Kind())
+}
+
+// recorder is a bounded ring buffer of events.  It has its own lock, so that
+// misses can be recorded with Mapper.mux read-locked.
+type recorder struct {
+	mux    sync.Mutex
+	events []Event
+	next   int // index of the oldest event, once the buffer is full
+	full   bool
+}
+
+func (r *recorder) record(op Op, key Key, st stamp) {
+	ev := Event{Op: op, Key: key, Goroutine: st.goroutine, Time: st.time}
+	r.mux.Lock()
+	r.events[r.next] = ev
+	r.next++
+	if r.next == len(r.events) {
+		r.next = 0
+		r.full = true
+	}
+	r.mux.Unlock()
+}
+
+// recent returns the recorded events, oldest first.
+func (r *recorder) recent() []Event {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if !r.full {
+		return append([]Event(nil), r.events[:r.next]...)
+	}
+	return append(append([]Event(nil), r.events[r.next:]...), r.events[:r.next]...)
+}
+
+// Recent returns the operations recorded by the flight recorder, oldest
+// first, or nil if the mapper was not created WithFlightRecorder.
+func (mapper *Mapper) Recent() []Event {
+	if mapper.recorder == nil {
+		return nil
+	}
+	return mapper.recorder.recent()
+}
+
+// WriteRecent writes the operations recorded by the flight recorder to w, one
+// per line, oldest first.
+func (mapper *Mapper) WriteRecent(w io.Writer) error {
+	var b strings.Builder
+	for _, ev := range mapper.Recent() {
+		fmt.Fprintln(&b, ev)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// stamp is the goroutine and time of a recorded operation.
+type stamp struct {
+	goroutine uint64
+	time      time.Time
+}
+
+// stamp returns the stamp of an operation by the calling goroutine, or the
+// zero stamp if the mapper has no flight recorder.  As goid formats a
+// traceback, it must not be called with mux held.
+func (mapper *Mapper) stamp() stamp {
+	if mapper.recorder == nil {
+		return stamp{}
+	}
+	return stamp{goroutine: goid(), time: mapper.now()}
+}
+
+// lock write-locks mux, after stamping the operations that are recorded until
+// it is unlocked.
+func (mapper *Mapper) lock() {
+	op := mapper.stamp()
+	mapper.mux.Lock()
+	mapper.op = op
+}
+
+// record records an operation, if the mapper has a flight recorder.  It
+// requires mux to be write-locked by lock.
+func (mapper *Mapper) record(op Op, key Key) {
+	if mapper.recorder != nil {
+		mapper.recorder.record(op, key, mapper.op)
+	}
+}
+
+// miss counts and records a lookup of a key that is not mapped, and annotates
+// err, the reason why, with the mapper's name and recent operations.  It must
+// not be called with mux held.
+func (mapper *Mapper) miss(key Key, err error) error {
+	mapper.countMiss()
+	var recent []Event
+	if mapper.recorder != nil {
+		mapper.recorder.record(OpMiss, key, mapper.stamp())
+		recent = mapper.recorder.recent()
+	}
+	switch err := err.(type) {
+	case *NotMappedError:
+		err.Mapper = mapper.name
+		err.Recent = recent
+	case *UseAfterDeleteError:
+		err.Mapper = mapper.name
+		err.Recent = recent
+	case *InvalidHandleError:
+		err.Mapper = mapper.name
+		err.Recent = recent
+	case *WrongMapperError:
+		err.Mapper = mapper.name
+		err.Owner = mapper.tagOwner(key)
+		err.Recent = recent
+	}
+	return err
+}
+
+// writeRecent writes events to b, one per line.
+func writeRecent(b *strings.Builder, events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	b.WriteString("\nrecent operations:")
+	for _, ev := range events {
+		fmt.Fprintf(b, "\n\t%v", ev)
+	}
+}
+
+// goid returns the current goroutine's ID, parsed from the header of its
+// stack trace: "goroutine 123 [running]:".
+func goid() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
@@ -0,0 +1,62 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.jpap.org/mapper"
+)
+
+func TestFlightRecorder(t *testing.T) {
+	m := mapper.New(mapper.WithFlightRecorder(3))
+
+	m.MapValue("dropped")
+	key := m.MapValue("value")
+	m.Delete(key)
+	m.Clear()
+
+	_, err := m.TryGet(key)
+	var uade *mapper.UseAfterDeleteError
+	if !errors.As(err, &uade) {
+		t.Fatalf("TryGet returned %v, want *UseAfterDeleteError", err)
+	}
+
+	want := []mapper.Op{mapper.OpDelete, mapper.OpClear, mapper.OpMiss}
+	for _, events := range [][]mapper.Event{m.Recent(), uade.Recent} {
+		if len(events) != len(want) {
+			t.Fatalf("recorded %v, want ops %v", events, want)
+		}
+		for i, ev := range events {
+			if ev.Op != want[i] || ev.Goroutine == 0 || ev.Time.IsZero() {
+				t.Errorf("event %d is %v, want op %v", i, ev, want[i])
+			}
+		}
+		if events[2].Key != key {
+			t.Errorf("miss recorded for key 0x%x, want 0x%x", events[2].Key.Handle(), key.Handle())
+		}
+	}
+	if msg := err.Error(); !strings.Contains(msg, "recent operations:") || !strings.Contains(msg, "clear") {
+		t.Errorf("error does not include recent operations:\n%s", msg)
+	}
+
+	var b strings.Builder
+	if err := m.WriteRecent(&b); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(b.String(), "\n"); n != 3 {
+		t.Errorf("WriteRecent wrote %d lines:\n%s", n, b.String())
+	}
+}
+
+func TestFlightRecorderDisabled(t *testing.T) {
+	var m mapper.Mapper
+	m.Delete(m.MapValue("value"))
+	if events := m.Recent(); events != nil {
+		t.Fatalf("Recent returned %v", events)
+	}
+}
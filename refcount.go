@@ -0,0 +1,63 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+import "fmt"
+
+// Retain increments the reference count of a mapping created RefCounted.
+//
+// It returns the error from TryGet if the key is not mapped, or an error if
+// the mapping is not reference counted.
+func (mapper *Mapper) Retain(key Key) error {
+	mapper.mux.Lock()
+	e, err := mapper.findLocked(key)
+	if err != nil {
+		mapper.mux.Unlock()
+		return mapper.miss(key, err)
+	}
+	refCounted := e.x != nil && e.x.refs > 0
+	if refCounted {
+		e.x.refs++
+	}
+	mapper.mux.Unlock()
+	if !refCounted {
+		return fmt.Errorf("key not reference counted: 0x%x", key.v)
+	}
+	return nil
+}
+
+// Release decrements the reference count of a mapping created RefCounted,
+// deleting the mapping when it reaches zero.  The OnRelease hook, if any, is
+// then called without the mapper locked, so it may use the mapper, followed by
+// the deletion hooks.
+//
+// Releasing a key that is no longer mapped returns an *OverReleaseError.
+// Release also returns an error if the mapping is not reference counted.
+func (mapper *Mapper) Release(key Key) error {
+	mapper.lock()
+	e, err := mapper.findLocked(key)
+	if err != nil {
+		mapper.mux.Unlock()
+		return &OverReleaseError{Key: key, Err: mapper.miss(key, err)}
+	}
+	x := e.x
+	if x == nil || x.refs == 0 {
+		mapper.mux.Unlock()
+		return fmt.Errorf("key not reference counted: 0x%x", key.v)
+	}
+	x.refs--
+	if x.refs > 0 {
+		mapper.mux.Unlock()
+		return nil
+	}
+	mapper.removeLocked(key)
+	mapper.mux.Unlock()
+
+	if x.onRelease != nil {
+		x.onRelease(key, e.value)
+	}
+	mapper.deleted(e)
+	return nil
+}
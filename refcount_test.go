@@ -0,0 +1,79 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"errors"
+	"testing"
+
+	"go.jpap.org/mapper"
+)
+
+func TestRefCounted(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		m    *mapper.Mapper
+	}{
+		{"map", mapper.New()},
+		{"table", mapper.New(mapper.WithHandleTable())},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := tc.m
+
+			var released []interface{}
+			key := m.MapValue("value", mapper.RefCounted(), mapper.OnRelease(func(k mapper.Key, v interface{}) {
+				// The hook may use the mapper.
+				if _, ok := m.Lookup(k); ok {
+					t.Error("mapping still present in release hook")
+				}
+				released = append(released, v)
+			}))
+
+			if err := m.Retain(key); err != nil {
+				t.Fatalf("Retain: %v", err)
+			}
+			if err := m.Release(key); err != nil {
+				t.Fatalf("Release: %v", err)
+			}
+			if got := m.Get(key); got != "value" {
+				t.Fatalf("Get after first Release returned %v", got)
+			}
+			if err := m.Release(key); err != nil {
+				t.Fatalf("Release: %v", err)
+			}
+			if _, ok := m.Lookup(key); ok {
+				t.Fatal("mapping present after final Release")
+			}
+			if len(released) != 1 || released[0] != "value" {
+				t.Fatalf("release hook called with %v", released)
+			}
+
+			err := m.Release(key)
+			var ore *mapper.OverReleaseError
+			if !errors.As(err, &ore) || ore.Key != key {
+				t.Fatalf("over-release returned %v, want *OverReleaseError", err)
+			}
+			if len(released) != 1 {
+				t.Fatal("release hook called on over-release")
+			}
+		})
+	}
+}
+
+func TestNotRefCounted(t *testing.T) {
+	var m mapper.Mapper
+	key := m.MapValue("value")
+	defer m.Delete(key)
+
+	if err := m.Retain(key); err == nil {
+		t.Fatal("Retain of plain mapping succeeded")
+	}
+	if err := m.Release(key); err == nil {
+		t.Fatal("Release of plain mapping succeeded")
+	}
+	if got := m.Get(key); got != "value" {
+		t.Fatalf("Get returned %v", got)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// The process-wide registry of named mappers.
+var (
+	registryMux sync.Mutex
+	registry    = make(map[string]*Mapper)
+)
+
+func init() {
+	G.name = "G"
+	registry[G.name] = &G
+}
+
+// NewNamed is like New, but gives the mapper a name, and registers it in the
+// process-wide registry, where it can be found with Named and Mappers, e.g.
+// by package debughttp.  The name also appears in errors and Stats.  G is
+// registered as "G".
+//
+// NewNamed panics if the name is empty or already registered.  The registry
+// keeps the mapper alive until it is unregistered.
+func NewNamed(name string, opts ...Option) *Mapper {
+	if name == "" {
+		panic("mapper name is empty")
+	}
+	mapper := New(opts...)
+	mapper.name = name
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	if _, ok := registry[name]; ok {
+		panic(fmt.Errorf("mapper name already registered: %q", name))
+	}
+	registry[name] = mapper
+	return mapper
+}
+
+// Named returns the registered mapper with the given name.
+func Named(name string) (mapper *Mapper, ok bool) {
+	registryMux.Lock()
+	mapper, ok = registry[name]
+	registryMux.Unlock()
+	return
+}
+
+// Mappers returns all registered mappers, sorted by name.
+func Mappers() []*Mapper {
+	registryMux.Lock()
+	mappers := make([]*Mapper, 0, len(registry))
+	for _, mapper := range registry {
+		mappers = append(mappers, mapper)
+	}
+	registryMux.Unlock()
+	sort.Slice(mappers, func(i, j int) bool {
+		return mappers[i].name < mappers[j].name
+	})
+	return mappers
+}
+
+// tagOwner returns the name of the registered mapper that tagged key, when
+// it has the same tag width as mapper, or the empty string.
+func (mapper *Mapper) tagOwner(key Key) string {
+	tag := key.v & mapper.format.tagMask
+	if tag == 0 {
+		return ""
+	}
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	for name, other := range registry {
+		if other != mapper && other.format.tagMask == mapper.format.tagMask && other.format.tag == tag {
+			return name
+		}
+	}
+	return ""
+}
+
+// Name returns the name the mapper was created with by NewNamed, or the empty
+// string.
+func (mapper *Mapper) Name() string {
+	return mapper.name
+}
+
+// Unregister removes the mapper from the registry, after which its name may
+// be reused.  The mapper keeps its name, and remains usable.
+func (mapper *Mapper) Unregister() {
+	registryMux.Lock()
+	if registry[mapper.name] == mapper {
+		delete(registry, mapper.name)
+	}
+	registryMux.Unlock()
+}
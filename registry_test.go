@@ -0,0 +1,53 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"strings"
+	"testing"
+
+	"go.jpap.org/mapper"
+)
+
+func TestRegistry(t *testing.T) {
+	if g, ok := mapper.Named("G"); !ok || g != &mapper.G || g.Name() != "G" {
+		t.Fatal("G is not registered as \"G\"")
+	}
+
+	m := mapper.NewNamed("TestRegistry")
+	if got, ok := mapper.Named("TestRegistry"); !ok || got != m {
+		t.Fatal("Named did not find the new mapper")
+	}
+	found := false
+	for _, mm := range mapper.Mappers() {
+		found = found || mm == m
+	}
+	if !found {
+		t.Fatal("Mappers did not list the new mapper")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("NewNamed with a duplicate name did not panic")
+			}
+		}()
+		mapper.NewNamed("TestRegistry")
+	}()
+
+	_, err := m.TryGetHandle(1)
+	if err == nil || !strings.HasPrefix(err.Error(), "mapper TestRegistry: ") {
+		t.Errorf("error does not name the mapper: %v", err)
+	}
+	if name := m.Stats().Name; name != "TestRegistry" {
+		t.Errorf("Stats has name %q", name)
+	}
+
+	m.Unregister()
+	if _, ok := mapper.Named("TestRegistry"); ok {
+		t.Fatal("Named found an unregistered mapper")
+	}
+	mapper.NewNamed("TestRegistry").Unregister()
+}
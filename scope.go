@@ -0,0 +1,168 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// Scope creates mappings on a Mapper, and deletes them all when it is closed.
+// Use a Scope when a single operation, such as a request, creates several
+// mappings that share its lifetime:
+//
+//   scope := mapper.G.NewScope()
+//   defer scope.Close()
+//   key := scope.MapValue(v)
+//
+// Scopes nest: closing a Scope first closes the Scopes created from it.  A
+// Scope is safe for concurrent use.
+type Scope struct {
+	mapper *Mapper
+	parent *Scope
+
+	mux      sync.Mutex
+	entries  []*entry
+	prune    int // len(entries) at which deleted mappings are dropped
+	children map[*Scope]struct{}
+	closed   bool
+}
+
+// minPrune is the smallest number of entries a Scope tracks before it drops
+// the mappings that were deleted elsewhere.
+const minPrune = 64
+
+// NewScope returns a new Scope that creates mappings on the mapper.
+func (mapper *Mapper) NewScope() *Scope {
+	return &Scope{mapper: mapper}
+}
+
+// NewScope returns a new child Scope, that is closed when s is closed.  It
+// panics if s is closed.
+func (s *Scope) NewScope() *Scope {
+	child := &Scope{mapper: s.mapper, parent: s}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.checkOpenLocked()
+	if s.children == nil {
+		s.children = make(map[*Scope]struct{})
+	}
+	s.children[child] = struct{}{}
+	return child
+}
+
+// Mapper returns the mapper that the Scope creates mappings on.
+func (s *Scope) Mapper() *Mapper {
+	return s.mapper
+}
+
+// MapPair is like Mapper.MapPair, but the mapping is deleted when the Scope is
+// closed.  It panics if s is closed.
+func (s *Scope) MapPair(key Key, goValue interface{}, opts ...MapOption) {
+	e := s.mapper.newEntry(goValue, opts)
+	s.track(e, func() (*entry, []*entry) {
+		return s.mapper.mapPair(key, e)
+	})
+}
+
+// MapPtrPair is like Mapper.MapPtrPair, but the mapping is deleted when the
+// Scope is closed.  It panics if s is closed.
+func (s *Scope) MapPtrPair(ptr unsafe.Pointer, goValue interface{}, opts ...MapOption) Key {
+	key := KeyFromPtr(ptr)
+	s.MapPair(key, goValue, opts...)
+	return key
+}
+
+// MapValue is like Mapper.MapValue, but the mapping is deleted when the Scope
+// is closed.  It panics if s is closed.
+func (s *Scope) MapValue(goValue interface{}, opts ...MapOption) (key Key) {
+	e := s.mapper.newEntry(goValue, opts)
+	s.track(e, func() (old *entry, full []*entry) {
+		key, full = s.mapper.allocValue(e)
+		return nil, full
+	})
+	return key
+}
+
+// track calls mapEntry to map e, and records e for Close.  The Scope stays
+// locked while mapping, so that a concurrent Close can't miss e, but the hooks
+// of the replaced and evicted mappings are called after it is unlocked, so
+// that they may use the Scope.
+func (s *Scope) track(e *entry, mapEntry func() (old *entry, full []*entry)) {
+	old, full := s.add(e, mapEntry)
+	if old != nil {
+		s.mapper.deleted(old)
+	}
+	s.mapper.evictedAll(full)
+}
+
+// add is the locked part of track.
+func (s *Scope) add(e *entry, mapEntry func() (*entry, []*entry)) (old *entry, full []*entry) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.checkOpenLocked()
+	old, full = mapEntry()
+	s.entries = append(s.entries, e)
+	if len(s.entries) >= minPrune && len(s.entries) >= s.prune {
+		s.pruneLocked()
+	}
+	return old, full
+}
+
+// pruneLocked drops the entries that are no longer mapped, so that a
+// long-lived Scope whose mappings are deleted before it is closed does not
+// grow without bound.  The next prune is when the Scope has doubled again.
+func (s *Scope) pruneLocked() {
+	mapper := s.mapper
+	mapper.mux.RLock()
+	live := s.entries[:0]
+	for _, e := range s.entries {
+		if mapper.mappedLocked(e) {
+			live = append(live, e)
+		}
+	}
+	mapper.mux.RUnlock()
+	for i := len(live); i < len(s.entries); i++ {
+		s.entries[i] = nil
+	}
+	s.entries = live
+	s.prune = 2 * len(live)
+}
+
+// Close closes the Scope's children, and then deletes the mappings created by
+// the Scope, newest first, calling their deletion hooks.  Mappings that have
+// already been deleted, or replaced by another owner, are left alone.
+// Closing a closed Scope has no effect.
+func (s *Scope) Close() {
+	s.mux.Lock()
+	if s.closed {
+		s.mux.Unlock()
+		return
+	}
+	s.closed = true
+	children := s.children
+	entries := s.entries
+	s.children = nil
+	s.entries = nil
+	s.mux.Unlock()
+
+	for child := range children {
+		child.Close()
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		s.mapper.removeEntry(entries[i], nil)
+	}
+	if s.parent != nil {
+		s.parent.mux.Lock()
+		delete(s.parent.children, s)
+		s.parent.mux.Unlock()
+	}
+}
+
+func (s *Scope) checkOpenLocked() {
+	if s.closed {
+		panic("scope is closed")
+	}
+}
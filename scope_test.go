@@ -0,0 +1,139 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"testing"
+	"unsafe"
+
+	"go.jpap.org/mapper"
+)
+
+func TestScopeClose(t *testing.T) {
+	var order []interface{}
+	m := mapper.New(mapper.WithOnDelete(func(k mapper.Key, v interface{}) {
+		order = append(order, v)
+	}))
+
+	buf := make([]uint64, 2)
+	ptr := unsafe.Pointer(&buf[0])
+
+	scope := m.NewScope()
+	a := scope.MapValue("a")
+	scope.MapPtrPair(ptr, "ptr")
+	child := scope.NewScope()
+	c := child.MapValue("child")
+	b := scope.MapValue("b")
+	keep := m.MapValue("keep")
+
+	if m.Len() != 5 {
+		t.Fatalf("Len returned %d, want 5", m.Len())
+	}
+	scope.Close()
+	scope.Close() // no effect
+
+	for _, key := range []mapper.Key{a, b, c, mapper.KeyFromPtr(ptr)} {
+		if m.Has(key) {
+			t.Errorf("key 0x%x still mapped after Close", key.Handle())
+		}
+	}
+	if !m.Has(keep) {
+		t.Fatal("unscoped mapping deleted by Close")
+	}
+	want := []interface{}{"child", "b", "ptr", "a"}
+	if len(order) != len(want) {
+		t.Fatalf("hooks called for %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("hooks called for %v, want %v", order, want)
+		}
+	}
+}
+
+func TestScopeLeavesReplacedMappings(t *testing.T) {
+	var m mapper.Mapper
+	buf := make([]uint64, 2)
+	ptr := unsafe.Pointer(&buf[0])
+
+	scope := m.NewScope()
+	scope.MapPtrPair(ptr, "scoped")
+	deleted := scope.MapValue("deleted")
+	m.Delete(deleted)
+	m.MapPtrPair(ptr, "replaced")
+	scope.Close()
+
+	if got := m.GetPtr(ptr); got != "replaced" {
+		t.Fatalf("GetPtr returned %v after Close", got)
+	}
+	m.DeletePtr(ptr)
+}
+
+func TestScopeChildClose(t *testing.T) {
+	m := mapper.New(mapper.WithHandleTable())
+	scope := m.NewScope()
+	child := scope.NewScope()
+	key := child.MapValue("child")
+	child.Close()
+	if m.Has(key) {
+		t.Fatal("key still mapped after child Close")
+	}
+	key = scope.MapValue("parent")
+	scope.Close()
+	if m.Len() != 0 {
+		t.Fatalf("Len returned %d after Close", m.Len())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("MapValue on closed scope did not panic")
+		}
+	}()
+	scope.MapValue("closed")
+}
+
+func TestScopeHooks(t *testing.T) {
+	var scope *mapper.Scope
+	var evicted []interface{}
+	m := mapper.New(mapper.WithCapacity(1), mapper.WithOnEvict(func(k mapper.Key, v interface{}, reason mapper.EvictReason) {
+		evicted = append(evicted, v)
+		if v == "first" {
+			// Hooks may use the Scope that evicted the mapping.
+			scope.MapValue("from hook")
+		}
+	}))
+	scope = m.NewScope()
+	scope.MapValue("first")
+	scope.MapValue("second")
+	scope.Close()
+
+	want := []interface{}{"first", "second"}
+	if len(evicted) != len(want) || evicted[0] != want[0] || evicted[1] != want[1] {
+		t.Fatalf("OnEvict called for %v, want %v", evicted, want)
+	}
+	if m.Len() != 0 {
+		t.Fatalf("Len returned %d after Close", m.Len())
+	}
+}
+
+func TestScopeChurn(t *testing.T) {
+	var deleted int
+	m := mapper.New(mapper.WithOnDelete(func(k mapper.Key, v interface{}) {
+		deleted++
+	}))
+	scope := m.NewScope()
+	keep := scope.MapValue("keep")
+	for i := 0; i < 10000; i++ {
+		m.Delete(scope.MapValue(i))
+	}
+	if !m.Has(keep) {
+		t.Fatal("live scoped mapping dropped")
+	}
+	deleted = 0
+	scope.Close()
+	if deleted != 1 || m.Len() != 0 {
+		t.Fatalf("Close called %d hooks, left %d mappings", deleted, m.Len())
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+import (
+	"iter"
+	"math/bits"
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+)
+
+// cacheLineSize is used to pad shards so that their locks don't share a cache
+// line.
+const cacheLineSize = 64
+
+// ShardedMapper is like Mapper, but splits its mappings across a number of
+// independently locked shards, selected by a hash of the Key.  Use it instead
+// of a Mapper when many threads hit the same mapper concurrently, and the
+// single Mapper lock shows up in mutex profiles.
+//
+// A ShardedMapper must be created with NewShardedMapper.
+type ShardedMapper struct {
+	shards []shard
+	shift  uint
+
+	// atomicKey is shared by all shards so that counting keys are unique across
+	// the ShardedMapper; see Mapper.atomicKey.
+	atomicKey uintptr
+}
+
+type shard struct {
+	Mapper
+	_ [cacheLineSize]byte
+}
+
+// NewShardedMapper returns a new ShardedMapper with the given number of
+// shards, rounded up to a power of two.  If n <= 0, the number of shards is
+// derived from GOMAXPROCS.
+func NewShardedMapper(n int) *ShardedMapper {
+	if n <= 0 {
+		n = 4 * runtime.GOMAXPROCS(0)
+	}
+	shardBits := uint(bits.Len(uint(n - 1)))
+	return &ShardedMapper{
+		shards: make([]shard, 1<<shardBits),
+		shift:  64 - shardBits,
+	}
+}
+
+// shard returns the Mapper responsible for the given key.
+func (sm *ShardedMapper) shard(key Key) *Mapper {
+	// Fibonacci hashing: pointer keys are aligned and counting keys are
+	// sequential, so we need to mix the bits before taking the top ones.
+	h := uint64(key.v) * 0x9e3779b97f4a7c15
+	return &sm.shards[h>>sm.shift].Mapper
+}
+
+// MapPair creates a mapping between the provided Key and Go values.
+func (sm *ShardedMapper) MapPair(key Key, goValue interface{}, opts ...MapOption) {
+	sm.shard(key).MapPair(key, goValue, opts...)
+}
+
+// MapPtrPair is like MapPair, but maps from the given cgo pointer, and returns
+// the associated Key.
+func (sm *ShardedMapper) MapPtrPair(ptr unsafe.Pointer, goValue interface{}, opts ...MapOption) Key {
+	key := KeyFromPtr(ptr)
+	sm.MapPair(key, goValue, opts...)
+	return key
+}
+
+// MapValue maps and returns a new Key for the given Go value.  See
+// Mapper.MapValue for the limits on the key-space.
+func (sm *ShardedMapper) MapValue(goValue interface{}, opts ...MapOption) Key {
+	next := atomic.AddUintptr(&sm.atomicKey, 2)
+	// Crash on wrap-around
+	if next == 0 {
+		panic("key space exhausted")
+	}
+	key := Key{next | countingPointerBit}
+	sm.MapPair(key, goValue, opts...)
+	return key
+}
+
+// Get retrieves the Go value from the given key; see Mapper.Get.
+func (sm *ShardedMapper) Get(key Key) (goValue interface{}) {
+	return sm.shard(key).Get(key)
+}
+
+// GetPtr calls Get after first converting the given cgo pointer to a Key.
+func (sm *ShardedMapper) GetPtr(ptr unsafe.Pointer) (goValue interface{}) {
+	return sm.Get(Key{uintptr(ptr)})
+}
+
+// GetHandle calls Get after first converting the given handle to a Key.
+func (sm *ShardedMapper) GetHandle(handle uintptr) (goValue interface{}) {
+	return sm.Get(KeyFromHandle(handle))
+}
+
+// TryGet is like Get, but returns a *NotMappedError instead of panicking when
+// the key is not mapped.
+func (sm *ShardedMapper) TryGet(key Key) (goValue interface{}, err error) {
+	return sm.shard(key).TryGet(key)
+}
+
+// TryGetPtr calls TryGet after first converting the given cgo pointer to a
+// Key.
+func (sm *ShardedMapper) TryGetPtr(ptr unsafe.Pointer) (goValue interface{}, err error) {
+	return sm.TryGet(Key{uintptr(ptr)})
+}
+
+// TryGetHandle calls TryGet after first converting the given handle to a Key.
+func (sm *ShardedMapper) TryGetHandle(handle uintptr) (goValue interface{}, err error) {
+	return sm.TryGet(KeyFromHandle(handle))
+}
+
+// Lookup retrieves the Go value from the given key, and reports whether the
+// key was mapped.
+func (sm *ShardedMapper) Lookup(key Key) (goValue interface{}, ok bool) {
+	return sm.shard(key).Lookup(key)
+}
+
+// LookupPtr calls Lookup after first converting the given cgo pointer to a
+// Key.
+func (sm *ShardedMapper) LookupPtr(ptr unsafe.Pointer) (goValue interface{}, ok bool) {
+	return sm.Lookup(Key{uintptr(ptr)})
+}
+
+// LookupHandle calls Lookup after first converting the given handle to a Key.
+func (sm *ShardedMapper) LookupHandle(handle uintptr) (goValue interface{}, ok bool) {
+	return sm.Lookup(KeyFromHandle(handle))
+}
+
+// Delete an existing mapping via the given key.
+func (sm *ShardedMapper) Delete(key Key) {
+	sm.shard(key).Delete(key)
+}
+
+// DeletePtr deletes an existing mapping from the given cgo pointer.
+func (sm *ShardedMapper) DeletePtr(ptr unsafe.Pointer) {
+	sm.Delete(KeyFromPtr(ptr))
+}
+
+// DeleteHandle deletes an existing mapping from the given handle.
+func (sm *ShardedMapper) DeleteHandle(handle uintptr) {
+	sm.Delete(Key{handle})
+}
+
+// TryDelete deletes an existing mapping via the given key, and reports
+// whether a mapping was removed.
+func (sm *ShardedMapper) TryDelete(key Key) (deleted bool) {
+	return sm.shard(key).TryDelete(key)
+}
+
+// TryDeletePtr calls TryDelete after first converting the given cgo pointer to
+// a Key.
+func (sm *ShardedMapper) TryDeletePtr(ptr unsafe.Pointer) (deleted bool) {
+	return sm.TryDelete(KeyFromPtr(ptr))
+}
+
+// TryDeleteHandle calls TryDelete after first converting the given handle to a
+// Key.
+func (sm *ShardedMapper) TryDeleteHandle(handle uintptr) (deleted bool) {
+	return sm.TryDelete(Key{handle})
+}
+
+// Retain increments the reference count of a mapping; see Mapper.Retain.
+func (sm *ShardedMapper) Retain(key Key) error {
+	return sm.shard(key).Retain(key)
+}
+
+// Release decrements the reference count of a mapping; see Mapper.Release.
+func (sm *ShardedMapper) Release(key Key) error {
+	return sm.shard(key).Release(key)
+}
+
+// Borrow retrieves and guards a mapping; see Mapper.Borrow.
+func (sm *ShardedMapper) Borrow(key Key) (goValue interface{}, release func(), err error) {
+	return sm.shard(key).Borrow(key)
+}
+
+// DeleteAndWait deletes a mapping, and waits for its borrows to be released;
+// see Mapper.DeleteAndWait.
+func (sm *ShardedMapper) DeleteAndWait(key Key) (deleted bool) {
+	return sm.shard(key).DeleteAndWait(key)
+}
+
+// Clear all mappings.
+//
+// Shards are cleared one at a time, so a concurrent MapPair may survive the
+// Clear.  Counting keys are not reused after a Clear.
+func (sm *ShardedMapper) Clear() {
+	for i := range sm.shards {
+		sm.shards[i].Clear()
+	}
+}
+
+// ClearAndWait is like Clear, but then waits for all outstanding borrows to
+// be released.
+func (sm *ShardedMapper) ClearAndWait() {
+	for i := range sm.shards {
+		sm.shards[i].ClearAndWait()
+	}
+}
+
+// Len returns the number of live mappings.  Shards are counted one at a time,
+// so the result is approximate while the ShardedMapper is being modified.
+func (sm *ShardedMapper) Len() int {
+	n := 0
+	for i := range sm.shards {
+		n += sm.shards[i].Len()
+	}
+	return n
+}
+
+// Has reports whether the key is mapped.
+func (sm *ShardedMapper) Has(key Key) bool {
+	return sm.shard(key).Has(key)
+}
+
+// Range calls fn for each mapping until fn returns false; see Mapper.Range.
+// Each shard is visited in turn, from its own snapshot.
+func (sm *ShardedMapper) Range(fn func(key Key, goValue interface{}) bool) {
+	for i := range sm.shards {
+		more := true
+		sm.shards[i].Range(func(key Key, goValue interface{}) bool {
+			more = fn(key, goValue)
+			return more
+		})
+		if !more {
+			return
+		}
+	}
+}
+
+// All returns an iterator over the mappings, with the same semantics as
+// Range.
+func (sm *ShardedMapper) All() iter.Seq2[Key, any] {
+	return sm.Range
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"sync"
+	"testing"
+
+	"go.jpap.org/mapper"
+)
+
+func TestShardedMapper(t *testing.T) {
+	sm := mapper.NewShardedMapper(8)
+
+	const n = 1000
+	keys := make([]mapper.Key, n)
+	for i := range keys {
+		keys[i] = sm.MapValue(i)
+	}
+	for i, key := range keys {
+		if got := sm.GetHandle(key.Handle()); got != i {
+			t.Fatalf("GetHandle(0x%x) = %v, want %d", key.Handle(), got, i)
+		}
+	}
+	for _, key := range keys[:n/2] {
+		if !sm.TryDelete(key) {
+			t.Fatalf("TryDelete(0x%x) returned false", key.Handle())
+		}
+	}
+	for i, key := range keys {
+		_, ok := sm.Lookup(key)
+		if want := i >= n/2; ok != want {
+			t.Fatalf("Lookup(0x%x) ok = %v, want %v", key.Handle(), ok, want)
+		}
+	}
+
+	sm.Clear()
+	if _, err := sm.TryGet(keys[n-1]); err == nil {
+		t.Fatal("TryGet after Clear succeeded")
+	}
+}
+
+func TestShardedMapperConcurrent(t *testing.T) {
+	sm := mapper.NewShardedMapper(0)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := sm.MapValue(g)
+				if got := sm.Get(key); got != g {
+					t.Errorf("Get(0x%x) = %v, want %d", key.Handle(), got, g)
+				}
+				sm.Delete(key)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+// benchKeys is the number of live mappings used by the benchmarks.
+const benchKeys = 1024
+
+func BenchmarkMapperGetParallel(b *testing.B) {
+	var m mapper.Mapper
+	benchGetParallel(b, m.MapValue, m.Get)
+}
+
+func BenchmarkShardedMapperGetParallel(b *testing.B) {
+	sm := mapper.NewShardedMapper(0)
+	benchGetParallel(b, sm.MapValue, sm.Get)
+}
+
+func BenchmarkMapperMixedParallel(b *testing.B) {
+	var m mapper.Mapper
+	benchMixedParallel(b, m.MapValue, m.Get, m.Delete)
+}
+
+func BenchmarkShardedMapperMixedParallel(b *testing.B) {
+	sm := mapper.NewShardedMapper(0)
+	benchMixedParallel(b, sm.MapValue, sm.Get, sm.Delete)
+}
+
+func benchGetParallel(b *testing.B, mapValue func(interface{}, ...mapper.MapOption) mapper.Key, get func(mapper.Key) interface{}) {
+	keys := make([]mapper.Key, benchKeys)
+	for i := range keys {
+		keys[i] = mapValue(i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			get(keys[i%benchKeys])
+			i++
+		}
+	})
+}
+
+// benchMixedParallel runs a callback-like workload: mostly lookups, with one
+// in 16 operations creating and deleting a short-lived mapping.
+func benchMixedParallel(b *testing.B, mapValue func(interface{}, ...mapper.MapOption) mapper.Key, get func(mapper.Key) interface{}, del func(mapper.Key)) {
+	keys := make([]mapper.Key, benchKeys)
+	for i := range keys {
+		keys[i] = mapValue(i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if i%16 == 0 {
+				del(mapValue(i))
+			} else {
+				get(keys[i%benchKeys])
+			}
+			i++
+		}
+	})
+}
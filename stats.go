@@ -0,0 +1,130 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+import (
+	"expvar"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Stats holds the occupancy and traffic counters of a Mapper.
+type Stats struct {
+	Name      string // name of the mapper, if created by NewNamed
+	Live      int    // number of live mappings
+	HighWater int    // maximum number of live mappings
+	Maps      uint64 // number of mappings created
+	Deletes   uint64 // number of mappings deleted, including by Clear
+	Hits      uint64 // number of lookups of mapped keys, with WithTrafficStats
+	Misses    uint64 // number of lookups of unmapped keys, with WithTrafficStats
+
+	// KeySpace is the number of counting keys that MapValue can still
+	// allocate; with a handle table, across the generations of its slots.
+	KeySpace uint64
+}
+
+// Stats returns a snapshot of the mapper's counters.
+func (mapper *Mapper) Stats() Stats {
+	mapper.mux.RLock()
+	stats := Stats{
+		Name:      mapper.name,
+		Live:      len(mapper.m),
+		HighWater: mapper.highWater,
+		Maps:      mapper.maps,
+		Deletes:   mapper.deletes,
+	}
+	if mapper.table != nil {
+		stats.Live += mapper.table.n
+		stats.KeySpace = mapper.table.keySpace()
+	} else {
+		stats.KeySpace = uint64((^mapper.format.reserved()&^countingPointerBit - mapper.atomicKey) / 2)
+	}
+	mapper.mux.RUnlock()
+
+	if t := mapper.traffic.Load(); t != nil {
+		stats.Hits = t.hits.sum()
+		stats.Misses = t.misses.sum()
+	}
+	return stats
+}
+
+// Publish publishes the mapper's Stats via expvar under the given name, e.g.
+// for /debug/vars.  Like expvar.Publish, it panics if the name is already in
+// use.
+func (mapper *Mapper) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return mapper.Stats()
+	}))
+}
+
+// countMapLocked counts a new mapping.
+func (mapper *Mapper) countMapLocked() {
+	mapper.maps++
+	live := len(mapper.m)
+	if mapper.table != nil {
+		live += mapper.table.n
+	}
+	if live > mapper.highWater {
+		mapper.highWater = live
+	}
+}
+
+// countHit counts a lookup of a mapped key.
+func (mapper *Mapper) countHit() {
+	if mapper.trafficStats {
+		mapper.counters().hits.add()
+	}
+}
+
+// countMiss counts a lookup of a key that was not mapped.
+func (mapper *Mapper) countMiss() {
+	if mapper.trafficStats {
+		mapper.counters().misses.add()
+	}
+}
+
+// traffic counts lookups when enabled by WithTrafficStats.  Lookups are the
+// hot path, and may run in parallel without a lock (see WithReadMostly), so
+// the counters are striped by goroutine, to keep threads looking up the same
+// hot key from contending on a cache line.
+type traffic struct {
+	hits, misses stripedCounter
+}
+
+// counters returns the mapper's traffic counters, allocating them on first
+// use so that an idle Mapper stays small.
+func (mapper *Mapper) counters() *traffic {
+	if t := mapper.traffic.Load(); t != nil {
+		return t
+	}
+	mapper.traffic.CompareAndSwap(nil, new(traffic))
+	return mapper.traffic.Load()
+}
+
+const counterStripeBits = 4
+
+type stripedCounter [1 << counterStripeBits]struct {
+	n atomic.Uint64
+	_ [cacheLineSize - 8]byte
+}
+
+func (c *stripedCounter) add() {
+	// Goroutine stacks don't overlap, and are at least 2 KiB, so the address
+	// of a local variable, less its low 11 bits, tells running goroutines
+	// apart without the cost of a random number.  A stack that grows moves,
+	// which only changes its stripe.  Fibonacci hashing, as in
+	// ShardedMapper.shard, then mixes the address into a stripe.
+	var local byte
+	h := uint64(uintptr(unsafe.Pointer(&local))>>11) * 0x9e3779b97f4a7c15
+	c[h>>(64-counterStripeBits)].n.Add(1)
+}
+
+func (c *stripedCounter) sum() uint64 {
+	var n uint64
+	for i := range c {
+		n += c[i].n.Load()
+	}
+	return n
+}
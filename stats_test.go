@@ -0,0 +1,95 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"go.jpap.org/mapper"
+)
+
+func TestStats(t *testing.T) {
+	m := mapper.New(mapper.WithReadMostly(), mapper.WithTrafficStats())
+
+	a := m.MapValue("a")
+	b := m.MapValue("b")
+	m.Get(a)
+	m.Get(b)
+	m.Lookup(a)
+	m.Delete(a)
+	m.Lookup(a)
+	m.MapValue("c")
+
+	stats := m.Stats()
+	want := mapper.Stats{Live: 2, HighWater: 2, Maps: 3, Deletes: 1, Hits: 3, Misses: 1}
+	want.KeySpace = stats.KeySpace
+	if stats != want {
+		t.Fatalf("Stats returned %+v, want %+v", stats, want)
+	}
+	if stats.KeySpace == 0 {
+		t.Error("KeySpace is zero")
+	}
+
+	m.Clear()
+	if stats := m.Stats(); stats.Live != 0 || stats.Deletes != 3 || stats.HighWater != 2 {
+		t.Fatalf("Stats after Clear returned %+v", stats)
+	}
+}
+
+func TestStatsNoTraffic(t *testing.T) {
+	m := mapper.New()
+	key := m.MapValue("value")
+	m.Get(key)
+	m.Delete(key)
+	m.Lookup(key)
+	if stats := m.Stats(); stats.Hits != 0 || stats.Misses != 0 || stats.Maps != 1 {
+		t.Fatalf("Stats without WithTrafficStats returned %+v", stats)
+	}
+}
+
+func TestStatsHandleTable(t *testing.T) {
+	m := mapper.New(mapper.WithHandleTable())
+	before := m.Stats().KeySpace
+	key := m.MapValue("value")
+	if stats := m.Stats(); stats.Live != 1 || stats.KeySpace != before-1 {
+		t.Fatalf("Stats returned %+v, KeySpace before %d", stats, before)
+	}
+	// The deleted key's generation is used up, so the slot's reuse doesn't
+	// give it back.
+	m.Delete(key)
+	if stats := m.Stats(); stats.Live != 0 || stats.KeySpace != before-1 {
+		t.Fatalf("Stats after Delete returned %+v, KeySpace before %d", stats, before)
+	}
+
+	// Each bit of a tag halves the generations of each slot.
+	tagged := mapper.New(mapper.WithHandleTable(), mapper.WithTag(4))
+	if got := tagged.Stats().KeySpace; got != before>>4 {
+		t.Fatalf("KeySpace WithTag(4) is %d, want %d", got, before>>4)
+	}
+}
+
+// publishRuns makes the expvar name of each run of TestPublish unique, as
+// expvar can't unpublish a name.
+var publishRuns atomic.Int32
+
+func TestPublish(t *testing.T) {
+	name := fmt.Sprintf("mapper_test.TestPublish.%d", publishRuns.Add(1))
+	m := mapper.New()
+	m.Publish(name)
+	m.MapValue("value")
+
+	var stats mapper.Stats
+	v := expvar.Get(name)
+	if err := json.Unmarshal([]byte(v.String()), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Live != 1 || stats.Maps != 1 {
+		t.Fatalf("published %+v", stats)
+	}
+}
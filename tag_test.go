@@ -0,0 +1,77 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.jpap.org/mapper"
+)
+
+func TestTagWrongMapper(t *testing.T) {
+	for _, opts := range [][]mapper.Option{
+		{mapper.WithTag(4)},
+		{mapper.WithTag(4), mapper.WithHandleTable()},
+		{mapper.WithHandleTable(), mapper.WithTag(4), mapper.WithReadMostly()},
+	} {
+		owner := mapper.NewNamed("owner", opts...)
+		other := mapper.NewNamed("other", opts...)
+
+		key := owner.MapValue("owner")
+		other.MapValue("other")
+		if got := owner.Get(key); got != "owner" {
+			t.Fatalf("Get returned %v", got)
+		}
+
+		_, err := other.TryGet(key)
+		var wme *mapper.WrongMapperError
+		if !errors.As(err, &wme) {
+			t.Fatalf("TryGet on other mapper returned %v, want *WrongMapperError", err)
+		}
+		if wme.Key != key || wme.Mapper != "other" || wme.Owner != "owner" {
+			t.Fatalf("WrongMapperError is %+v", wme)
+		}
+		if !strings.Contains(err.Error(), "issued by mapper owner") {
+			t.Fatalf("error %q does not name the owner", err)
+		}
+		var nme *mapper.NotMappedError
+		if !errors.As(err, &nme) || nme.Key != key || nme.Mapper != "other" {
+			t.Fatalf("WrongMapperError does not match *NotMappedError: %v", nme)
+		}
+		if other.TryDelete(key) {
+			t.Fatal("TryDelete on other mapper returned true")
+		}
+
+		owner.Unregister()
+		other.Unregister()
+		owner.Clear()
+		other.Clear()
+	}
+}
+
+func TestTagUseAfterDelete(t *testing.T) {
+	m := mapper.New(mapper.WithTag(8))
+	key := m.MapValue("value")
+	m.Delete(key)
+	_, err := m.TryGet(key)
+	var uade *mapper.UseAfterDeleteError
+	if !errors.As(err, &uade) {
+		t.Fatalf("TryGet of deleted key returned %v", err)
+	}
+	if ks := m.Stats().KeySpace; ks == 0 || ks >= 1<<(64-8) {
+		t.Fatalf("KeySpace is %d", ks)
+	}
+}
+
+func TestTagWidth(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("WithTag(0) did not panic")
+		}
+	}()
+	mapper.WithTag(0)
+}
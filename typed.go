@@ -0,0 +1,212 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// TypedMapper is a type-safe view onto a Mapper that only maps values of type
+// T.  Values are returned as T directly, so callbacks don't need a type
+// assertion that could panic deep inside a cgo call.
+//
+// The zero TypedMapper is ready to use, and has its own private Mapper.  Use
+// NewTypedMapper to share an existing Mapper (e.g. G) with untyped users.  A
+// TypedMapper must not be copied after first use.
+type TypedMapper[T any] struct {
+	shared *Mapper
+	own    Mapper
+}
+
+// TypedKey is a Key that was mapped by a TypedMapper[T].
+type TypedKey[T any] struct {
+	Key
+}
+
+// NewTypedMapper returns a TypedMapper for values of type T that is backed by
+// the given Mapper.  If mapper is nil, the TypedMapper has its own private
+// Mapper.
+func NewTypedMapper[T any](mapper *Mapper) *TypedMapper[T] {
+	return &TypedMapper[T]{shared: mapper}
+}
+
+// TypedKeyFromPtr is like KeyFromPtr, but returns a TypedKey.
+func TypedKeyFromPtr[T any](ptr unsafe.Pointer) TypedKey[T] {
+	return TypedKey[T]{KeyFromPtr(ptr)}
+}
+
+// TypedKeyFromHandle is like KeyFromHandle, but returns a TypedKey.
+func TypedKeyFromHandle[T any](handle uintptr) TypedKey[T] {
+	return TypedKey[T]{KeyFromHandle(handle)}
+}
+
+// Mapper returns the underlying (untyped) Mapper.
+func (tm *TypedMapper[T]) Mapper() *Mapper {
+	if tm.shared != nil {
+		return tm.shared
+	}
+	return &tm.own
+}
+
+// MapPair creates a mapping between the provided key and Go value.
+func (tm *TypedMapper[T]) MapPair(key TypedKey[T], goValue T, opts ...MapOption) {
+	tm.Mapper().MapPair(key.Key, goValue, opts...)
+}
+
+// MapPtrPair is like MapPair, but maps from the given cgo pointer, and returns
+// the associated key.
+func (tm *TypedMapper[T]) MapPtrPair(ptr unsafe.Pointer, goValue T, opts ...MapOption) TypedKey[T] {
+	return TypedKey[T]{tm.Mapper().MapPtrPair(ptr, goValue, opts...)}
+}
+
+// MapValue maps and returns a new key for the given Go value.
+func (tm *TypedMapper[T]) MapValue(goValue T, opts ...MapOption) TypedKey[T] {
+	return TypedKey[T]{tm.Mapper().MapValue(goValue, opts...)}
+}
+
+// Get retrieves the Go value from the given key.
+func (tm *TypedMapper[T]) Get(key TypedKey[T]) T {
+	return tm.cast(key.Key, tm.Mapper().Get(key.Key))
+}
+
+// GetPtr calls Get after first converting the given cgo pointer to a key.
+func (tm *TypedMapper[T]) GetPtr(ptr unsafe.Pointer) T {
+	return tm.cast(Key{uintptr(ptr)}, tm.Mapper().GetPtr(ptr))
+}
+
+// GetHandle calls Get after first converting the given handle to a key.
+func (tm *TypedMapper[T]) GetHandle(handle uintptr) T {
+	return tm.cast(Key{handle}, tm.Mapper().GetHandle(handle))
+}
+
+// TryGet is like Get, but returns an error instead of panicking: the error
+// from Mapper.TryGet if the key is not mapped, or an error if it is mapped to
+// a value that is not a T.
+func (tm *TypedMapper[T]) TryGet(key TypedKey[T]) (goValue T, err error) {
+	v, err := tm.Mapper().TryGet(key.Key)
+	if err != nil {
+		return goValue, err
+	}
+	return tm.convert(key.Key, v)
+}
+
+// TryGetPtr calls TryGet after first converting the given cgo pointer to a
+// key.
+func (tm *TypedMapper[T]) TryGetPtr(ptr unsafe.Pointer) (goValue T, err error) {
+	return tm.TryGet(TypedKey[T]{Key{uintptr(ptr)}})
+}
+
+// TryGetHandle calls TryGet after first converting the given handle to a key.
+func (tm *TypedMapper[T]) TryGetHandle(handle uintptr) (goValue T, err error) {
+	return tm.TryGet(TypedKeyFromHandle[T](handle))
+}
+
+// Lookup retrieves the Go value from the given key, and reports whether the
+// key was mapped to a T.
+func (tm *TypedMapper[T]) Lookup(key TypedKey[T]) (goValue T, ok bool) {
+	goValue, err := tm.TryGet(key)
+	return goValue, err == nil
+}
+
+// LookupPtr calls Lookup after first converting the given cgo pointer to a
+// key.
+func (tm *TypedMapper[T]) LookupPtr(ptr unsafe.Pointer) (goValue T, ok bool) {
+	return tm.Lookup(TypedKey[T]{Key{uintptr(ptr)}})
+}
+
+// LookupHandle calls Lookup after first converting the given handle to a key.
+func (tm *TypedMapper[T]) LookupHandle(handle uintptr) (goValue T, ok bool) {
+	return tm.Lookup(TypedKeyFromHandle[T](handle))
+}
+
+// Delete an existing mapping via the given key.
+func (tm *TypedMapper[T]) Delete(key TypedKey[T]) {
+	tm.Mapper().Delete(key.Key)
+}
+
+// DeletePtr deletes an existing mapping from the given cgo pointer.
+func (tm *TypedMapper[T]) DeletePtr(ptr unsafe.Pointer) {
+	tm.Mapper().DeletePtr(ptr)
+}
+
+// DeleteHandle deletes an existing mapping from the given handle.
+func (tm *TypedMapper[T]) DeleteHandle(handle uintptr) {
+	tm.Mapper().DeleteHandle(handle)
+}
+
+// TryDelete deletes an existing mapping via the given key, and reports
+// whether a mapping was removed.
+func (tm *TypedMapper[T]) TryDelete(key TypedKey[T]) (deleted bool) {
+	return tm.Mapper().TryDelete(key.Key)
+}
+
+// TryDeletePtr calls TryDelete after first converting the given cgo pointer
+// to a key.
+func (tm *TypedMapper[T]) TryDeletePtr(ptr unsafe.Pointer) (deleted bool) {
+	return tm.Mapper().TryDeletePtr(ptr)
+}
+
+// TryDeleteHandle calls TryDelete after first converting the given handle to a
+// key.
+func (tm *TypedMapper[T]) TryDeleteHandle(handle uintptr) (deleted bool) {
+	return tm.Mapper().TryDeleteHandle(handle)
+}
+
+// Retain increments the reference count of a mapping; see Mapper.Retain.
+func (tm *TypedMapper[T]) Retain(key TypedKey[T]) error {
+	return tm.Mapper().Retain(key.Key)
+}
+
+// Release decrements the reference count of a mapping; see Mapper.Release.
+func (tm *TypedMapper[T]) Release(key TypedKey[T]) error {
+	return tm.Mapper().Release(key.Key)
+}
+
+// Borrow retrieves and guards a mapping; see Mapper.Borrow.
+func (tm *TypedMapper[T]) Borrow(key TypedKey[T]) (goValue T, release func(), err error) {
+	v, release, err := tm.Mapper().Borrow(key.Key)
+	if err != nil {
+		return goValue, nil, err
+	}
+	if goValue, err = tm.convert(key.Key, v); err != nil {
+		// Don't leave the mapping borrowed.
+		release()
+		return goValue, nil, err
+	}
+	return goValue, release, nil
+}
+
+// DeleteAndWait deletes a mapping, and waits for its borrows to be released;
+// see Mapper.DeleteAndWait.
+func (tm *TypedMapper[T]) DeleteAndWait(key TypedKey[T]) (deleted bool) {
+	return tm.Mapper().DeleteAndWait(key.Key)
+}
+
+// cast converts goValue to T, where a nil goValue is the zero T, e.g. a nil
+// error.  This can only fail when the underlying Mapper is shared, and an
+// untyped user mapped a different type under the same key.
+func (tm *TypedMapper[T]) cast(key Key, goValue interface{}) T {
+	v, err := tm.convert(key, goValue)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// convert is like cast, but returns an error instead of panicking.
+func (tm *TypedMapper[T]) convert(key Key, goValue interface{}) (T, error) {
+	if goValue == nil {
+		var zero T
+		return zero, nil
+	}
+	v, ok := goValue.(T)
+	if !ok {
+		want := reflect.TypeOf((*T)(nil)).Elem()
+		return v, fmt.Errorf("key 0x%x mapped to %T, not %v", key.v, goValue, want)
+	}
+	return v, nil
+}
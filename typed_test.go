@@ -0,0 +1,107 @@
+// Copyright 2021 John Papandriopoulos.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapper_test
+
+import (
+	"testing"
+	"unsafe"
+
+	"go.jpap.org/mapper"
+)
+
+type typedValue struct {
+	msg string
+}
+
+func TestTypedMapper(t *testing.T) {
+	var tm mapper.TypedMapper[*typedValue]
+
+	v := &typedValue{"hello"}
+	key := tm.MapValue(v)
+	defer tm.Delete(key)
+
+	if got := tm.Get(key); got != v {
+		t.Fatalf("Get returned %p, want %p", got, v)
+	}
+	if got := tm.GetHandle(key.Handle()); got != v {
+		t.Fatalf("GetHandle returned %p, want %p", got, v)
+	}
+}
+
+func TestTypedMapperShared(t *testing.T) {
+	var m mapper.Mapper
+	tm := mapper.NewTypedMapper[string](&m)
+
+	key := tm.MapValue("typed")
+	if got := m.Get(key.Key); got != "typed" {
+		t.Fatalf("untyped Get returned %v", got)
+	}
+
+	// An untyped user maps a different type; the typed Get must panic with a
+	// descriptive error, rather than a bare type assertion failure.
+	other := m.MapValue(42)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("GetHandle of mismatched type did not panic")
+		}
+	}()
+	tm.GetHandle(other.Handle())
+}
+
+func TestTypedMapperTryGet(t *testing.T) {
+	var m mapper.Mapper
+	tm := mapper.NewTypedMapper[string](&m)
+
+	key := tm.MapValue("typed")
+	if got, err := tm.TryGetHandle(key.Handle()); err != nil || got != "typed" {
+		t.Fatalf("TryGetHandle returned %q, %v", got, err)
+	}
+	if _, err := tm.TryGetHandle(m.MapValue(42).Handle()); err == nil {
+		t.Fatal("TryGetHandle of mismatched type succeeded")
+	}
+	if !tm.TryDelete(key) || tm.TryDelete(key) {
+		t.Fatal("TryDelete did not delete the mapping once")
+	}
+	buf := make([]byte, 16)
+	if tm.TryDeletePtr(unsafe.Pointer(&buf[1])) {
+		t.Fatal("TryDeletePtr of unaligned pointer returned true")
+	}
+	if _, ok := tm.Lookup(key); ok {
+		t.Fatal("Lookup of deleted key succeeded")
+	}
+	if _, err := tm.TryGet(key); err == nil {
+		t.Fatal("TryGet of deleted key succeeded")
+	}
+	m.Clear()
+}
+
+func TestTypedMapperNilInterface(t *testing.T) {
+	var tm mapper.TypedMapper[error]
+
+	key := tm.MapValue(nil)
+	if err := tm.Get(key); err != nil {
+		t.Fatalf("Get returned %v, want nil", err)
+	}
+	v, release, err := tm.Borrow(key)
+	if err != nil || v != nil {
+		t.Fatalf("Borrow returned %v, %v", v, err)
+	}
+	release()
+	tm.Delete(key)
+}
+
+func TestTypedMapperBorrowMismatch(t *testing.T) {
+	var m mapper.Mapper
+	tm := mapper.NewTypedMapper[string](&m)
+
+	key := mapper.TypedKey[string]{m.MapValue(42)}
+	if _, release, err := tm.Borrow(key); err == nil || release != nil {
+		t.Fatalf("Borrow of mismatched type returned %v", err)
+	}
+	// The failed Borrow released the mapping, so this doesn't block.
+	if !tm.DeleteAndWait(key) {
+		t.Fatal("DeleteAndWait returned false")
+	}
+}
@@ -11,14 +11,20 @@
 // Our `Mapper` does this by associating an opaque `Key` with the Go object,
 // and having the caller pass the key's handle to the C code.  Later, in a Go
 // callback, the Go object can be obtained using the `Get` method on the
-// `Mapper` in exchange for the opaque pointer (key).
+// `Mapper` in exchange for the opaque pointer (key).  `Get` panics if the key
+// is not mapped, which is fatal inside a callback running on a C thread; use
+// `Lookup` or `TryGet` to handle a missing key gracefully.
 //
 // You can create a `Mapper` object for each different category of mapping, or
 // reuse the same one for all, with the caveats of mapping limits described
 // below.  A global mapper, `G` is provided for your convenience.
 //
 // Internally, the mapper uses a RWLock-protected Go map to associate `Keys`
-// with Go values.  The following patterns are supported.
+// with Go values.  When many threads hit the same mapper concurrently, a
+// `ShardedMapper` splits the mappings across several independently locked
+// shards.  For mappers that are rarely modified, `New(WithReadMostly())` gives
+// a `Mapper` whose lookups take no lock at all.  The following patterns are
+// supported.
 //
 //
 // Mapping a Go Object with an Existing Cgo Pointer
@@ -50,6 +56,199 @@
 // system by using multiple `Mapper`s, each for different categories of object
 // mappings, instead of the global map `G`.
 //
+// Alternatively, a `Mapper` created `WithHandleTable` encodes a slot index and
+// generation in each key, reusing slots once their mappings are deleted, so that
+// a key used after its mapping was deleted is reported as such rather than
+// resolving to a newer value.  Its key space is no larger: on a 32-bit
+// platform, up to 65,536 mappings may be live at once, and 2^31 keys can be
+// issued in all.
+//
+//
+// Type-Safe Mappings
+//
+// A `TypedMapper[T]` wraps a `Mapper` so that values of type `T` are mapped
+// and retrieved without a type assertion at each call site.  Keys returned by
+// a `TypedMapper[T]` are of type `TypedKey[T]`.  A `TypedMapper` can share an
+// existing `Mapper` with untyped users via `NewTypedMapper`.
+//
+//
+// Reference-Counted Mappings
+//
+// Some C libraries hand the same user pointer to several independent
+// callbacks or registrations.  A mapping created with the `RefCounted` option
+// is shared between them: each additional owner calls `Retain`, and each owner
+// calls `Release` when done.  The mapping is deleted once the count reaches
+// zero, after which the `OnRelease` hook, if any, is called.
+//
+//
+// Borrowing Mapped Values
+//
+// A callback running on a C thread may still be using a Go value while another
+// goroutine deletes its mapping.  To guard against this, a callback can
+// `Borrow` the value, and call the returned release function when done.  The
+// owner then deletes the mapping with `DeleteAndWait`, which rejects new
+// borrows, and blocks until the outstanding ones are released.
+//
+//
+// Deletion Hooks
+//
+// A mapping is often paired with a C resource that must be freed when the
+// mapping is deleted.  A deletion hook, set for a single mapping with the
+// `OnDelete` option or for all mappings of a `Mapper` created `WithOnDelete`,
+// is called exactly once when the mapping is removed by any means, including
+// `Clear`.  Hooks are called without the mapper locked, so they may call back
+// into it.
+//
+//
+// Finding Leaked Mappings
+//
+// To find out who created mappings that were never deleted, create the
+// `Mapper` `WithDebug`, or build with the `mapperdebug` tag to enable debug
+// mode for all mappers including `G`.  Each mapping then records its creation
+// time and stack, and `Leaks` lists the live mappings with their creation
+// sites.  Package `mappertest` provides a `TestMain` helper that fails the
+// test binary if any mapping outlives the tests.
+//
+// In a running program, a `Mapper` created `WithProfile` adds its live
+// mappings to the "mapper.live" `runtime/pprof` profile, so that
+// `go tool pprof` can show the code paths that created them.
+//
+//
+// Diagnosing Use After Delete
+//
+// A `Mapper` created `WithQuarantine` keeps tombstones for a bounded number of
+// recently deleted keys.  Looking up one of those keys returns a
+// `UseAfterDeleteError` that says when, and in debug mode from where, the key
+// was deleted, rather than a `NotMappedError` that cannot tell a deleted key
+// from one that was never mapped or was forged by memory corruption.
+//
+//
+// Flight Recorder
+//
+// A `Mapper` created `WithFlightRecorder` keeps a bounded log of its recent
+// operations: new mappings, lookups of keys that are not mapped, deletions and
+// clears.  The log can be dumped with `WriteRecent`, and is attached to the
+// error (and so the panic from `Get`) for a key that is not mapped, so that a
+// crash report shows what happened to the mapper just before.
+//
+//
+// Inspecting a Mapper
+//
+// `Len` and `Has` report the number of live mappings, and whether a key is
+// mapped.  `Range`, or `All` for use with a `for` loop, visits each mapping
+// from a snapshot taken when it was called, without the mapper locked, so the
+// visited mappings can be deleted along the way, e.g. on shutdown.
+//
+//
+// Metrics
+//
+// Each `Mapper` counts its live mappings, their high-water mark, the mappings
+// created and deleted, lookup hits and misses, and the remaining counting-key
+// space.  `Stats` returns a snapshot of the counters, and `Publish` exports
+// them via `expvar`.  Lookup hits and misses are only counted with
+// `WithTrafficStats`, as even an uncontended atomic increment is a large share
+// of the cost of `Get` in read-mostly mode; the counters are striped by
+// goroutine, so threads looking up the same hot key don't contend on them.
+//
+//
+// Named Mappers
+//
+// `NewNamed` creates a `Mapper` with a name, and registers it in a
+// process-wide registry, where it can be found with `Named` and `Mappers`.
+// `G` is registered as "G".  A mapper's name appears in the errors for keys
+// it has not mapped, and in its `Stats`.
+//
+//
+// Inspecting a Running Program
+//
+// Importing package `debughttp` registers an HTTP handler under
+// `/debug/mapper/`, in the manner of `net/http/pprof`, that lists the named
+// mappers of a running program with their sizes, key kinds and value types,
+// and their mappings with creation times and stacks in debug mode, as HTML or
+// JSON.
+//
+//
+// Scoped Mappings
+//
+// When a single operation, such as a request, creates several mappings that
+// share its lifetime, create them on a `Scope` from `NewScope`, and defer its
+// `Close` method.  Closing a `Scope` deletes its mappings, newest first, calling
+// their deletion hooks, and closes any child scopes created from it with
+// `Scope.NewScope`.  Mappings that were deleted, or replaced, in the meantime are
+// left alone.
+//
+//
+// Context-Bound Mappings
+//
+// A mapping created with `MapValueContext` is deleted when its context is done,
+// for mappings that must not outlive a request.  A callback that arrives after
+// that finds a `*UseAfterDeleteError` whose `Cause` is the context's cause, so
+// `errors.Is(err, context.Canceled)` distinguishes it from other lookup failures.
+// No goroutine is kept per mapping.
+//
+//
+// Expiring Mappings
+//
+// Some C libraries never say when they are done with a user pointer.  To keep
+// their mappings from living forever, a mapping created with the `TTL` option is
+// evicted once its time is up, a `Mapper` created `WithIdleTimeout` evicts the
+// mappings that have not been looked up for a while, and one created
+// `WithCapacity` evicts its least recently used mapping when full.  Eviction
+// hooks, set with `WithOnEvict` or `OnEvict`, are told the `EvictReason`, and
+// are followed by the deletion hooks.
+//
+// Expired mappings are evicted by a janitor goroutine, shared by all mappers,
+// that only runs while there is something to expire.  Tests can set a fake clock
+// `WithClock`, advance it, and call `Expire` to evict without delay.
+//
+//
+// Catching Keys from the Wrong Mapper
+//
+// With a `Mapper` per category of mapping, a key issued by one mapper may end up
+// being looked up in another, where it could even resolve to an unrelated value.
+// A `Mapper` created `WithTag` encodes a tag that identifies it in the highest
+// bits of its counting keys, so that such a lookup is reported as a
+// `*WrongMapperError`, naming the mapper that issued the key when it is
+// registered.  The tag width is configurable, as each bit halves the counting
+// key space, which matters on 32-bit platforms.
+//
+//
+// Hardened Handles
+//
+// Counting keys are small, predictable odd integers, so a C bug that writes
+// garbage over a handle can easily land on another live mapping.  A `Mapper`
+// created `WithHardenedHandles` adds check bits to its counting keys, keyed by a
+// secret chosen at random for each process, and reports a key whose check bits
+// are wrong as a `*InvalidHandleError` instead of resolving it.
+//
+//
+// Randomized Keys
+//
+// Sequential counting keys hide bugs where C code mixes up two handles, or does
+// arithmetic on them, as the result is likely another live key.  In tests, a
+// `Mapper` created `WithRandomKeys` issues its counting keys from a randomized,
+// sparse key space instead.  The keys are a seeded permutation of the sequential
+// ones, so they remain unique and odd, and a failing run can be reproduced with
+// the seed reported by `KeySeed`.
+//
+//
+// Unaligned Pointers
+//
+// Some C APIs hand back byte-aligned pointers, into packed buffers or string
+// tables, that `KeyFromPtr` rejects, as the low bit of a key marks a counting
+// key.  `MapRawPtrPair` accepts any pointer: an odd one is mapped in a separate
+// keyspace, via a counting key allocated for it, so that it can't collide with
+// the keys from `MapValue`.  Look it up with `GetRawPtr` and its variants.
+// Aligned pointers take the usual path.
+//
+//
+// Interior Pointers
+//
+// Some C libraries pass callbacks a pointer to a field inside a struct, or into a
+// buffer, rather than the pointer that was mapped.  `MapRange` maps a whole
+// memory range to a Go value, and `GetContaining` resolves any address within it,
+// with a binary search over the mapped ranges, which must not overlap.
+//
 //
 // Relation to Go 1.17 and Up
 //